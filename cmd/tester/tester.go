@@ -17,6 +17,7 @@ import (
 	"text/template"
 	"time"
 
+	dnsclient "github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/client/udp"
 )
 
@@ -50,7 +51,7 @@ func main() {
 		wg.Add(1)
 		go readDomains(mainContext, wg, *domainList, domainChan, *duplicates)
 
-		client := udp.NewUDPClient(*target)
+		client := udp.NewUDPClient(*target, 0)
 
 		durChan := make(chan time.Duration, 100)
 
@@ -117,7 +118,7 @@ func runner(ctx context.Context, wg *sync.WaitGroup, client *udp.UDPClient, doma
 			}
 			start := time.Now()
 			_, err := client.ResolveV4(domain)
-			if err != nil && !errors.Is(err, &udp.NoResponse{}) {
+			if err != nil && !errors.Is(err, dnsclient.ErrNoData) && !errors.Is(err, dnsclient.ErrNameError) {
 				durChan <- time.Duration(-1)
 				continue
 			}
@@ -1,14 +1,18 @@
 module github.com/bluguard/dnshield
 
-go 1.21
+go 1.23
 
 require (
 	github.com/goccy/go-json v0.10.2
-	github.com/valyala/fasthttp v1.50.0
+	github.com/quic-go/quic-go v0.54.0
 )
 
 require (
-	github.com/andybalholm/brotli v1.0.6 // indirect
-	github.com/klauspost/compress v1.17.1 // indirect
-	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	go.uber.org/mock v0.5.0 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
 )
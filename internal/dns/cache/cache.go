@@ -5,8 +5,9 @@ import (
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
 
+// Feedable is fed with the answer set of a question
 type Feedable interface {
-	Feed(dto.Record)
+	Feed(dto.Question, []dto.Record)
 }
 
 type Cache interface {
@@ -39,7 +39,7 @@ func (f *deadlineFolder) insert(d deadline) {
 		return
 	}
 
-	slices.Insert(f.memory, pos, d)
+	f.memory = slices.Insert(f.memory[:l-1], pos, d)
 }
 
 // shiftRightFrom shift to the right from the given position
@@ -0,0 +1,39 @@
+package memorycache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeadlineFolder_insert(t *testing.T) {
+	now := time.Now()
+	at := func(seconds int) time.Time {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	tests := []struct {
+		name    string
+		expiry  []int
+		wantKey []uint32
+	}{
+		{name: "in order", expiry: []int{1, 2, 3}, wantKey: []uint32{0, 1, 2}},
+		{name: "reversed", expiry: []int{3, 2, 1}, wantKey: []uint32{2, 1, 0}},
+		{name: "in the middle", expiry: []int{1, 5, 3, 4, 2}, wantKey: []uint32{0, 4, 2, 3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := deadlineFolder{}
+			for key, seconds := range tt.expiry {
+				f.insert(deadline{expiry: at(seconds), key: uint32(key)})
+			}
+			// each deadline is kept once, sorted by expiry
+			keys := make([]uint32, 0, len(f.memory))
+			for _, d := range f.memory {
+				keys = append(keys, d.key)
+			}
+			if !reflect.DeepEqual(keys, tt.wantKey) {
+				t.Errorf("deadlineFolder.insert() keys = %v, want %v", keys, tt.wantKey)
+			}
+		})
+	}
+}
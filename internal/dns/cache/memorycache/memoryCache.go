@@ -2,31 +2,33 @@ package memorycache
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"hash/fnv"
 	"log"
-	"net"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/bluguard/dnshield/internal/dns/cache"
+	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
 
-// estimate cost of one entry is 50 bytes
+// estimate cost of one record is 50 bytes
 const cost int64 = 50
 const defaultTTL = 60
 
-const (
-	v4Suffix = "_v4"
-	v6Suffix = "_v6"
-)
-
 var _ cache.Cache = &MemoryCache{}
 
+// entry is the answer set cached for a question
+type entry struct {
+	records []dto.Record
+	cost    int64
+}
+
 // MemoryCache an in memory cache implementation
 type MemoryCache struct {
-	memory          map[uint32]net.IP
+	memory          map[uint32]entry
 	lock            *sync.RWMutex
 	deadlines       *deadlineFolder
 	remainingMemory int64
@@ -38,7 +40,7 @@ type MemoryCache struct {
 // NewMemoryCache instantiate a new cache
 func NewMemoryCache(ctx context.Context, wg *sync.WaitGroup, size int64, baseTTL uint32, forceTTL bool, gcDelay time.Duration) *MemoryCache {
 	res := MemoryCache{
-		memory:          make(map[uint32]net.IP),
+		memory:          make(map[uint32]entry),
 		lock:            &sync.RWMutex{},
 		deadlines:       &deadlineFolder{memory: make([]deadline, 0, 50)},
 		remainingMemory: size,
@@ -57,57 +59,44 @@ func NewMemoryCache(ctx context.Context, wg *sync.WaitGroup, size int64, baseTTL
 	return &res
 }
 
-// ResolveV4 implements cache.Cache
-func (c *MemoryCache) ResolveV4(name string) (dto.Record, error) {
-	ip, err := c.resolve(name + v4Suffix)
-	if err != nil {
-		return dto.Record{}, err
-	}
-	return dto.Record{
-		Name:  name,
-		Type:  dto.A,
-		Class: dto.IN,
-		TTL:   defaultTTL,
-		Data:  ip.To4(),
-	}, nil
+// Resolve implements cache.Cache
+func (c *MemoryCache) Resolve(_ context.Context, question dto.Question) ([]dto.Record, error) {
+	return c.resolve(computeName(question))
 }
 
-// ResolveV6 implements cache.Cache
-func (c *MemoryCache) ResolveV6(name string) (dto.Record, error) {
-	ip, err := c.resolve(name + v6Suffix)
-	if err != nil {
-		return dto.Record{}, err
-	}
-	return dto.Record{
-		Name:  name,
-		Type:  dto.AAAA,
-		Class: dto.IN,
-		TTL:   defaultTTL,
-		Data:  ip.To16(),
-	}, nil
-}
-
-func (c *MemoryCache) resolve(name string) (net.IP, error) {
+func (c *MemoryCache) resolve(name string) ([]dto.Record, error) {
 	res := c.get(name)
 	if res == nil {
-		return nil, errors.New("no entry found for " + name)
+		return nil, fmt.Errorf("no entry for %s: %w", name, client.ErrNotFound)
+	}
+	records := make([]dto.Record, len(res))
+	for i, record := range res {
+		record.TTL = defaultTTL
+		records[i] = record
 	}
-	return res, nil
+	return records, nil
 }
 
 // Feed implements cache.Cache
-func (c *MemoryCache) Feed(record dto.Record) {
-	if c.totalCapacity < cost {
+// The answer set is cached as a whole, for the lowest TTL of its records
+func (c *MemoryCache) Feed(question dto.Question, records []dto.Record) {
+	if len(records) == 0 {
+		return
+	}
+	if c.totalCapacity < cost*int64(len(records)) {
 		return
 	}
-	ttl := record.TTL
-	if record.TTL < c.baseTTL {
+	ttl := records[0].TTL
+	for _, record := range records[1:] {
+		ttl = min(ttl, record.TTL)
+	}
+	if ttl < c.baseTTL {
 		if !c.forceBaseTTL {
 			return
 		}
 		ttl = c.baseTTL // force to the minimum ttl
 	}
-	c.put(computeName(record.Name, record.Type), computeData(record.Data, record.Type), time.Duration(ttl)*time.Second)
+	c.put(computeName(question), records, time.Duration(ttl)*time.Second)
 }
 
 // Clear implements cache.Cache
@@ -118,36 +107,40 @@ func (c *MemoryCache) Clear() {
 		delete(c.memory, k)
 	}
 	c.deadlines.shiftLeftOf(len(c.deadlines.memory))
+	c.remainingMemory = c.totalCapacity
 }
 
-func (c *MemoryCache) put(key string, address net.IP, ttl time.Duration) {
+func (c *MemoryCache) put(key string, records []dto.Record, ttl time.Duration) {
 
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	if c.remainingMemory < cost {
-		log.Println("cache is full")
-		c.freeNextDeadline()
-	} else {
-		c.remainingMemory -= cost
-	}
-
 	hkey := hash(key)
 	if _, ok := c.memory[hkey]; ok {
 		return
 	}
-	c.memory[hkey] = address
+
+	e := entry{records: records, cost: cost * int64(len(records))}
+	if c.remainingMemory < e.cost {
+		log.Println("cache is full")
+		for c.remainingMemory < e.cost && len(c.deadlines.memory) > 0 {
+			c.freeNextDeadline()
+		}
+	}
+	c.remainingMemory -= e.cost
+
+	c.memory[hkey] = e
 	c.deadlines.insert(deadline{expiry: time.Now().Add(ttl), key: hkey})
 }
 
-func (c *MemoryCache) get(key string) net.IP {
+func (c *MemoryCache) get(key string) []dto.Record {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	res, ok := c.memory[hash(key)]
 	if !ok {
 		return nil
 	}
-	return res
+	return res.records
 }
 
 func (c *MemoryCache) gc() {
@@ -164,16 +157,18 @@ func (c *MemoryCache) gc() {
 		}
 
 		count++
+		c.remainingMemory += c.memory[d.key].cost
 		delete(c.memory, d.key)
 	}
 	i := count
 	c.deadlines.shiftLeftOf(i)
 	log.Println("GC cleared", count, "entries in", time.Since(start))
-	c.remainingMemory += cost * int64(count)
 }
 
 func (c *MemoryCache) freeNextDeadline() {
-	delete(c.memory, c.deadlines.memory[0].key)
+	key := c.deadlines.memory[0].key
+	c.remainingMemory += c.memory[key].cost
+	delete(c.memory, key)
 	c.deadlines.shiftLeftOf(1)
 }
 
@@ -182,26 +177,10 @@ func hash(s string) uint32 {
 	_, _ = h.Write([]byte(s))
 	return h.Sum32()
 }
-func computeName(s string, t dto.Type) string {
-	switch t {
-	case dto.A:
-		return s + v4Suffix
-	case dto.AAAA:
-		return s + v6Suffix
-	default:
-		return s + v4Suffix
-	}
-}
 
-func computeData(iP net.IP, t dto.Type) net.IP {
-	switch t {
-	case dto.A:
-		return iP.To4()
-	case dto.AAAA:
-		return iP.To16()
-	default:
-		return nil
-	}
+// computeName returns the key of the question in the cache
+func computeName(question dto.Question) string {
+	return question.Name + "_" + strconv.Itoa(int(question.Type)) + "_" + strconv.Itoa(int(question.Class))
 }
 
 func gcScheduler(ctx context.Context, wg *sync.WaitGroup, memoryCache *MemoryCache, gcDelay time.Duration) {
@@ -22,39 +22,42 @@ func TestMemoryCache(t *testing.T) {
 
 	cl := client.Client(memCache)
 
-	wantv6 := dto.Record{Name: "google.com", Type: dto.AAAA, Class: dto.IN, TTL: 60, Data: net.ParseIP("::1").To16()}
-	wantv4 := dto.Record{Name: "google.com", Type: dto.A, Class: dto.IN, TTL: 1, Data: net.ParseIP("127.0.0.1").To4()}
+	wantv6 := dto.Record{Name: "google.com", Type: dto.AAAA, Class: dto.IN, TTL: 60, Data: dto.IPData(net.ParseIP("::1").To16())}
+	wantv4 := dto.Record{Name: "google.com", Type: dto.A, Class: dto.IN, TTL: 1, Data: dto.IPData(net.ParseIP("127.0.0.1").To4())}
 
-	feedable.Feed(wantv6)
-	feedable.Feed(wantv4)
+	v4 := dto.Question{Name: "google.com", Type: dto.A, Class: dto.IN}
+	v6 := dto.Question{Name: "google.com", Type: dto.AAAA, Class: dto.IN}
+
+	feedable.Feed(v6, []dto.Record{wantv6})
+	feedable.Feed(v4, []dto.Record{wantv4})
 	wantv4.TTL = 60
 
-	res, err := cl.ResolveV4("google.com")
+	res, err := cl.Resolve(context.Background(), v4)
 	if err != nil {
 		t.Fatalf("error resolving v4 " + err.Error())
 	}
 
-	if !reflect.DeepEqual(res, wantv4) {
+	if !reflect.DeepEqual(res, []dto.Record{wantv4}) {
 		t.Fatalf("error resolving v4 %v, got %v ", wantv4, res)
 	}
 
-	res, err = cl.ResolveV6("google.com")
+	res, err = cl.Resolve(context.Background(), v6)
 	if err != nil {
 		t.Fatalf("error resolving v6 " + err.Error())
 	}
 
-	if !reflect.DeepEqual(res, wantv6) {
+	if !reflect.DeepEqual(res, []dto.Record{wantv6}) {
 		t.Fatalf("error resolving v6 %v, got %v ", wantv6, res)
 	}
 
 	time.Sleep(1 * time.Second)
 
-	_, err = cl.ResolveV4("google.com")
+	_, err = cl.Resolve(context.Background(), v4)
 	if err == nil {
 		t.Fatalf("it should have no more v4 entry in the cache")
 	}
 
-	_, err = cl.ResolveV6("google.com")
+	_, err = cl.Resolve(context.Background(), v6)
 	if err != nil {
 		t.Fatalf("it should still have v6 entry in the cache")
 	}
@@ -62,3 +65,40 @@ func TestMemoryCache(t *testing.T) {
 	cancelfunc()
 	wg.Wait()
 }
+
+func TestMemoryCacheAnswerSet(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	memCache := NewMemoryCache(ctx, wg, 1000, 1, false, time.Second*1)
+
+	want := []dto.Record{
+		{Name: "www.example.com", Type: dto.CNAME, Class: dto.IN, TTL: 60, Data: dto.NameData("edge.example.net")},
+		{Name: "edge.example.net", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{192, 0, 2, 1}},
+		{Name: "edge.example.net", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{192, 0, 2, 2}},
+	}
+	question := dto.Question{Name: "www.example.com", Type: dto.A, Class: dto.IN}
+	memCache.Feed(question, want)
+
+	res, err := memCache.Resolve(context.Background(), question)
+	if err != nil {
+		t.Fatalf("error resolving v4 " + err.Error())
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("expecting the whole answer set %v, got %v", want, res)
+	}
+
+	question.Type = dto.AAAA
+	if _, err = memCache.Resolve(context.Background(), question); err == nil {
+		t.Fatalf("the answer set of a v4 question should not answer v6")
+	}
+
+	question.Type = dto.MX
+	mx := []dto.Record{{Name: "www.example.com", Type: dto.MX, Class: dto.IN, TTL: 60, Data: dto.MXData{Preference: 10, Exchange: "mail.example.com"}}}
+	memCache.Feed(question, mx)
+	if res, err = memCache.Resolve(context.Background(), question); err != nil || !reflect.DeepEqual(res, mx) {
+		t.Fatalf("expecting the MX answer set %v, got %v, %v", mx, res, err)
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
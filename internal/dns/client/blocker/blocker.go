@@ -1,7 +1,7 @@
 package blocker
 
 import (
-	"errors"
+	"context"
 	"net"
 
 	"github.com/bluguard/dnshield/internal/dns/client"
@@ -11,40 +11,37 @@ import (
 var _ client.Client = &Blocker{}
 
 var (
-	v4Block = net.ParseIP("0.0.0.0").To4()
-	v6Block = net.ParseIP("::1").To16()
+	v4Block = dto.IPData(net.ParseIP("0.0.0.0").To4())
+	v6Block = dto.IPData(net.ParseIP("::1").To16())
 )
 
 const defaultTTl uint32 = 600
 
 type Blocker map[string]struct{}
 
-// ResolveV4 implements client.Client
-func (b *Blocker) ResolveV4(name string) (dto.Record, error) {
-	if b.contains(name) {
-		return dto.Record{
-			Name:  name,
-			Type:  dto.A,
-			Class: dto.IN,
-			TTL:   defaultTTl,
-			Data:  v4Block,
-		}, nil
+// Resolve implements client.Client
+// Blocked names are answered with unroutable addresses and have no record of any other type,
+// so that no other record (HTTPS address hints for instance) leaks the real address
+func (b *Blocker) Resolve(_ context.Context, question dto.Question) ([]dto.Record, error) {
+	if !b.contains(question.Name) {
+		return nil, client.ErrNotFound
 	}
-	return dto.Record{}, errors.New("not blocking")
-}
-
-// ResolveV6 implements client.Client
-func (b *Blocker) ResolveV6(name string) (dto.Record, error) {
-	if b.contains(name) {
-		return dto.Record{
-			Name:  name,
-			Type:  dto.AAAA,
-			Class: dto.IN,
-			TTL:   defaultTTl,
-			Data:  v6Block,
-		}, nil
+	var data dto.RData
+	switch question.Type {
+	case dto.A:
+		data = v4Block
+	case dto.AAAA:
+		data = v6Block
+	default:
+		return nil, client.ErrNoData
 	}
-	return dto.Record{}, errors.New("not blocking")
+	return []dto.Record{{
+		Name:  question.Name,
+		Type:  question.Type,
+		Class: dto.IN,
+		TTL:   defaultTTl,
+		Data:  data,
+	}}, nil
 }
 
 func (b Blocker) contains(name string) bool {
@@ -0,0 +1,26 @@
+package blocker
+
+import (
+	"context"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ client.Client = PrivateReverse{}
+
+// PrivateReverse answers the reverse names of private, loopback and link local addresses as non existent,
+// these names only make sense on the local network and must not be sent to the public dns, see rfc6303
+type PrivateReverse struct{}
+
+// Resolve implements client.Client
+func (PrivateReverse) Resolve(_ context.Context, question dto.Question) ([]dto.Record, error) {
+	ip, ok := dto.ParseReverseName(question.Name)
+	if !ok {
+		return nil, client.ErrNotFound
+	}
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return nil, client.ErrNameError
+	}
+	return nil, client.ErrNotFound
+}
@@ -0,0 +1,32 @@
+package blocker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestPrivateReverse_Resolve(t *testing.T) {
+	tests := []struct {
+		name string
+		want error
+	}{
+		{name: "1.1.168.192.in-addr.arpa", want: client.ErrNameError},
+		{name: "1.0.0.127.in-addr.arpa", want: client.ErrNameError},
+		{name: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f.ip6.arpa", want: client.ErrNameError},
+		{name: "1.1.1.1.in-addr.arpa", want: client.ErrNotFound},
+		{name: "168.192.in-addr.arpa", want: client.ErrNotFound},
+		{name: "example.com", want: client.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := PrivateReverse{}.Resolve(context.Background(), dto.Question{Name: tt.name, Type: dto.PTR, Class: dto.IN})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("PrivateReverse.Resolve() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,65 @@
 package client
 
 import (
+	"context"
+	"errors"
+
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
 
+var (
+	// ErrNotFound is returned when the client has no record for the question, another source may know it
+	ErrNotFound = errors.New("no record found")
+	// ErrNameError is returned when the queried name does not exist
+	ErrNameError = errors.New("name does not exist")
+	// ErrNoData is returned when the queried name exists but has no record of the queried type
+	ErrNoData = errors.New("no record of the queried type")
+)
+
+var _ error = &NegativeAnswer{}
+
+// NegativeAnswer is a negative answer with the authority records telling how long it can be cached, see rfc2308.
+// It matches its Kind, ErrNameError or ErrNoData, with errors.Is
+type NegativeAnswer struct {
+	Kind error
+	// Authority the SOA record of the zone of the queried name
+	Authority []dto.Record
+}
+
+// Error implements error
+func (e *NegativeAnswer) Error() string {
+	return e.Kind.Error()
+}
+
+// Unwrap returns the kind of the negative answer
+func (e *NegativeAnswer) Unwrap() error {
+	return e.Kind
+}
+
+// Negative returns the negative answer of the given kind with the SOA records of the authority section,
+// the kind alone is returned when there is no SOA
+func Negative(kind error, authority []dto.Record) error {
+	var soa []dto.Record
+	for _, record := range authority {
+		if record.Type == dto.SOA {
+			soa = append(soa, record)
+		}
+	}
+	if len(soa) == 0 {
+		return kind
+	}
+	return &NegativeAnswer{Kind: kind, Authority: soa}
+}
+
+// Client resolves questions, the answer is the whole answer set of the question:
+// the records of the queried type, preceded by the CNAME chain leading to them if any.
+// The negative answers are ErrNameError or ErrNoData, wrapped in a NegativeAnswer when the SOA of the zone is known
 type Client interface {
-	ResolveV4(name string) (dto.Record, error)
-	ResolveV6(name string) (dto.Record, error)
+	Resolve(ctx context.Context, question dto.Question) ([]dto.Record, error)
 }
 
+// ReversableClient is a client able to find the names of an address
 type ReversableClient interface {
 	Client
-	ReverseResolve(ip string)
+	ReverseResolve(ctx context.Context, ip string) ([]dto.Record, error)
 }
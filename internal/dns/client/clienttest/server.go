@@ -0,0 +1,132 @@
+// Package clienttest provides the fixtures shared by the tests of the encrypted transports
+package clienttest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// Certificate returns a certificate of localhost signed by the issuer, it is self signed when the issuer is nil
+func Certificate(issuer *tls.Certificate, isCA bool) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	parent, signer := template, any(key)
+	if issuer != nil {
+		parent, signer = issuer.Leaf, issuer.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// Pin returns the base64 sha256 digest of the public key of the certificate
+func Pin(certificate *x509.Certificate) string {
+	digest := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// Roots returns a pool trusting the certificate
+func Roots(certificate *x509.Certificate) *x509.CertPool {
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	return roots
+}
+
+// Server is the state of a test server shared by the transports: its certificate and its connections
+type Server struct {
+	// Certificate sent by the server, the leaf is set
+	Certificate tls.Certificate
+	connections atomic.Int32
+	lock        sync.Mutex
+	open        []func()
+}
+
+// NewServer returns the state of a server sending the certificate, a self signed one when the certificate is empty
+func NewServer(certificate tls.Certificate) (*Server, error) {
+	if certificate.Leaf == nil {
+		var err error
+		if certificate, err = Certificate(nil, false); err != nil {
+			return nil, err
+		}
+	}
+	return &Server{Certificate: certificate}, nil
+}
+
+// TLSConfig returns the tls configuration of the server, advertising the alpn protocols
+func (s *Server) TLSConfig(protocols ...string) *tls.Config {
+	return &tls.Config{Certificates: []tls.Certificate{s.Certificate}, NextProtos: protocols}
+}
+
+// Pin returns the pin of the certificate of the server
+func (s *Server) Pin() string {
+	return Pin(s.Certificate.Leaf)
+}
+
+// Roots returns a pool trusting the certificate of the server
+func (s *Server) Roots() *x509.CertPool {
+	return Roots(s.Certificate.Leaf)
+}
+
+// Accepted counts a new connection, closing it with the close function when the server closes all its connections
+func (s *Server) Accepted(close func()) {
+	s.connections.Add(1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.open = append(s.open, close)
+}
+
+// Connections returns the number of connections accepted
+func (s *Server) Connections() int32 {
+	return s.connections.Load()
+}
+
+// CloseAll closes the open connections, like a server closing idle connections
+func (s *Server) CloseAll() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, close := range s.open {
+		close()
+	}
+	s.open = nil
+}
+
+// Answer returns the response to the query prefixed by its length, every A question is answered with 127.0.0.1
+func Answer(query *dto.Message) []byte {
+	response := dto.Message{ID: query.ID, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: query.Question, ResponseCount: 1,
+		Response: []dto.Record{{Name: query.Question[0].Name, Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{127, 0, 0, 1}}}}
+	out := dto.AppendMessage(make([]byte, 2, 512), &response)
+	binary.BigEndian.PutUint16(out, uint16(len(out)-2))
+	return out
+}
@@ -1,7 +1,11 @@
 package doh
 
 import (
+	"encoding/hex"
+	"errors"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
@@ -15,6 +19,8 @@ type Message struct {
 	CD       bool       `json:"CD,omitempty"`
 	Question []Question `json:"Question,omitempty"`
 	Answer   []Answer   `json:"Answer,omitempty"`
+	// Authority the SOA of the zone is sent with the negative answers
+	Authority []Answer `json:"Authority,omitempty"`
 }
 
 type Question struct {
@@ -29,22 +35,182 @@ type Answer struct {
 	Data string `json:"data,omitempty"`
 }
 
-func (a Answer) ToRecord() dto.Record {
-	ip := parseIp(a.Data)
+// ToRecord converts the answer to a record, an error is returned when its data can not be parsed
+func (a Answer) ToRecord() (dto.Record, error) {
+	data, err := parseData(dto.Type(a.Type), a.Data)
+	if err != nil {
+		return dto.Record{}, err
+	}
 	return dto.Record{
-		Name:  a.Name,
+		Name:  strings.TrimSuffix(a.Name, "."),
 		Type:  dto.Type(a.Type),
 		Class: dto.IN,
 		TTL:   a.Ttl,
-		Data:  ip,
+		Data:  data,
+	}, nil
+}
+
+// authority returns the records of the authority section which can be parsed
+func (m *Message) authority() []dto.Record {
+	res := make([]dto.Record, 0, len(m.Authority))
+	for _, answer := range m.Authority {
+		if record, err := answer.ToRecord(); err == nil {
+			res = append(res, record)
+		}
+	}
+	return res
+}
+
+// parseData parse the presentation format of the data returned by the json api.
+// The data which can not be parsed is an error, its text must not be sent as the wire format
+func parseData(t dto.Type, data string) (dto.RData, error) {
+	fields := strings.Fields(data)
+	if len(fields) > 0 && fields[0] == `\#` {
+		return parseRaw(fields, data)
+	}
+	var err error
+	switch t {
+	case dto.A, dto.AAAA:
+		ip := net.ParseIP(data)
+		if t == dto.A {
+			ip = ip.To4()
+		} else if !strings.Contains(data, ":") {
+			ip = nil // AAAA records are ipv6 addresses, the ipv4 ones would be serialized with the wrong length
+		}
+		if ip == nil {
+			return nil, malformed(t, data)
+		}
+		return dto.IPData(ip), nil
+	case dto.CNAME, dto.NS, dto.PTR:
+		if len(fields) != 1 {
+			return nil, malformed(t, data)
+		}
+		return dto.NameData(strings.TrimSuffix(fields[0], ".")), nil
+	case dto.MX:
+		if len(fields) != 2 {
+			return nil, malformed(t, data)
+		}
+		res := dto.MXData{Exchange: strings.TrimSuffix(fields[1], ".")}
+		res.Preference, err = parseUint16(fields[0])
+		if err != nil {
+			return nil, malformed(t, data)
+		}
+		return res, nil
+	case dto.SRV:
+		if len(fields) != 4 {
+			return nil, malformed(t, data)
+		}
+		res := dto.SRVData{Target: strings.TrimSuffix(fields[3], ".")}
+		for i, v := range []*uint16{&res.Priority, &res.Weight, &res.Port} {
+			if *v, err = parseUint16(fields[i]); err != nil {
+				return nil, malformed(t, data)
+			}
+		}
+		return res, nil
+	case dto.SOA:
+		if len(fields) != 7 {
+			return nil, malformed(t, data)
+		}
+		res := dto.SOAData{MName: strings.TrimSuffix(fields[0], "."), RName: strings.TrimSuffix(fields[1], ".")}
+		for i, v := range []*uint32{&res.Serial, &res.Refresh, &res.Retry, &res.Expire, &res.Minimum} {
+			if *v, err = parseUint32(fields[2+i]); err != nil {
+				return nil, malformed(t, data)
+			}
+		}
+		return res, nil
+	case dto.TXT:
+		return parseTXT(data)
 	}
+	// types without a dedicated representation, like HTTPS and SVCB, are only understood in the generic presentation
+	return nil, malformed(t, data)
+}
+
+// parseRaw parse the generic presentation of a data, see rfc3597 section 5
+func parseRaw(fields []string, data string) (dto.RData, error) {
+	if len(fields) < 2 {
+		return nil, errors.New("malformed generic data " + data)
+	}
+	raw, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil || strconv.Itoa(len(raw)) != fields[1] {
+		return nil, errors.New("malformed generic data " + data)
+	}
+	return dto.RawData(raw), nil
+}
+
+// malformed returns the error of a data which can not be parsed
+func malformed(t dto.Type, data string) error {
+	return errors.New("malformed data of type " + strconv.Itoa(int(t)) + ": " + data)
 }
 
-func parseIp(addr string) net.IP {
-	ip := net.ParseIP(addr)
-	v4 := ip.To4()
-	if v4 != nil {
-		return v4
+// parseTXT split the character-strings of a TXT record, quoted or delimited by spaces, see rfc1035 section 5.1
+func parseTXT(data string) (dto.RData, error) {
+	res := make(dto.TXTData, 0, 1)
+	for data = strings.TrimLeft(data, " \t"); data != ""; data = strings.TrimLeft(data, " \t") {
+		s, rest, err := characterString(data)
+		if err != nil {
+			return nil, malformed(dto.TXT, data)
+		}
+		res = append(res, s)
+		data = rest
 	}
-	return ip.To16()
+	return res, nil
+}
+
+// characterString reads the character-string at the start of the data and returns the remaining data.
+// A backslash escapes the next character, or an octet written as three decimal digits
+func characterString(data string) (string, string, error) {
+	quoted := data[0] == '"'
+	if quoted {
+		data = data[1:]
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case quoted && c == '"':
+			return b.String(), data[i+1:], nil
+		case !quoted && (c == ' ' || c == '\t'):
+			return b.String(), data[i:], nil
+		case c == '\\':
+			if i+1 >= len(data) {
+				return "", "", errors.New("escape at the end of the data")
+			}
+			if i+4 <= len(data) && isDigits(data[i+1:i+4]) {
+				v, err := strconv.ParseUint(data[i+1:i+4], 10, 8)
+				if err != nil {
+					return "", "", err
+				}
+				b.WriteByte(byte(v))
+				i += 3
+			} else {
+				b.WriteByte(data[i+1])
+				i++
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if quoted {
+		return "", "", errors.New("unterminated quoted string")
+	}
+	return b.String(), "", nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseUint16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 10, 16)
+	return uint16(v), err
+}
+
+func parseUint32(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	return uint32(v), err
 }
@@ -1,13 +1,13 @@
 package doh
 
 import (
-	"bytes"
+	"context"
 	"errors"
-	"log"
+	"net/http"
+	"net/url"
 	"strconv"
 
 	json "github.com/goccy/go-json"
-	"github.com/valyala/fasthttp"
 
 	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/dto"
@@ -15,76 +15,101 @@ import (
 
 var _ client.Client = &DOHClient{}
 
+// maxChainLength maximum number of queries sent to follow a CNAME chain
+const maxChainLength = 8
+
 // DOHClient Dns Pver Http clien, resolve request by requesting it to an http server
 type DOHClient struct {
 	endpoint   string
-	httpClient *fasthttp.Client
+	httpClient *http.Client
 }
 
 // NewDOHClient instantiate a new DOHClient
-func NewDOHClient(endpoint string) *DOHClient {
-	// t := http.DefaultTransport.(*http.Transport).Clone()
-	// t.MaxIdleConns = 100
-	// t.MaxConnsPerHost = 100
-	// t.MaxIdleConnsPerHost = 100
-
-	httpClient := &fasthttp.Client{
-		MaxConnsPerHost: 100,
+func NewDOHClient(conf Config) (*DOHClient, error) {
+	httpClient, err := newHTTPClient(conf)
+	if err != nil {
+		return nil, err
 	}
-	// &http.Client{
-	// 	Timeout:   10 * time.Second,
-	// 	Transport: t,
-	// }
-
 	return &DOHClient{
-		endpoint:   endpoint,
+		endpoint:   conf.Endpoint,
 		httpClient: httpClient,
-	}
+	}, nil
 }
 
-// ResolveV4 implements client.Client
-func (c *DOHClient) ResolveV4(name string) (dto.Record, error) {
-	return c.resolve(name, dto.A)
+// ResolveV4 resolve the ipv4 addresses of the name
+func (c *DOHClient) ResolveV4(name string) ([]dto.Record, error) {
+	return c.Resolve(context.Background(), dto.Question{Name: name, Type: dto.A, Class: dto.IN})
 }
 
-// ResolveV6 implements client.Client
-func (c *DOHClient) ResolveV6(name string) (dto.Record, error) {
-	return c.resolve(name, dto.AAAA)
+// ResolveV6 resolve the ipv6 addresses of the name
+func (c *DOHClient) ResolveV6(name string) ([]dto.Record, error) {
+	return c.Resolve(context.Background(), dto.Question{Name: name, Type: dto.AAAA, Class: dto.IN})
 }
 
-func (c *DOHClient) resolve(name string, t dto.Type) (dto.Record, error) {
-	req := fasthttp.AcquireRequest()
-	resp := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
+// Resolve implements client.Client
+// The CNAME chain is followed when the server did not follow it up to the end
+func (c *DOHClient) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, error) {
+	var records []dto.Record
+	name := question.Name
+	for i := 0; i < maxChainLength; i++ {
+		answers, err := c.query(ctx, name, question.Type)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, answers...)
+		last := records[len(records)-1]
+		target, ok := last.Data.(dto.NameData)
+		if !ok || last.Type != dto.CNAME || question.Type == dto.CNAME {
+			return records, nil
+		}
+		name = string(target)
+	}
+	return nil, errors.New("CNAME chain of " + question.Name + " is too long")
+}
 
-	req.SetRequestURI(c.endpoint + "?name=" + name + "&type=" + strconv.Itoa(int(t)))
-	req.Header.Add("accept", "application/dns-json")
-	req.Header.SetMethod("GET")
+func (c *DOHClient) query(ctx context.Context, name string, t dto.Type) ([]dto.Record, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+querySeparator(c.endpoint)+"name="+url.QueryEscape(name)+"&type="+strconv.Itoa(int(t)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("accept", "application/dns-json")
 
-	c.httpClient.Do(req, resp)
+	body, err := fetch(c.httpClient, req, "")
+	if err != nil {
+		return nil, err
+	}
 
 	var message Message
-	err := json.NewDecoder(bytes.NewReader(resp.Body())).Decode(&message)
+	err = json.Unmarshal(body, &message)
 
 	if err != nil {
-		return dto.Record{}, err
+		return nil, err
+	}
+	if dto.RCode(message.Status) == dto.NXDOMAIN {
+		return nil, client.Negative(client.ErrNameError, message.authority())
 	}
 	if message.Status > 0 {
-		return dto.Record{}, errors.New("status is " + strconv.Itoa(message.Status))
+		return nil, errors.New("status is " + strconv.Itoa(message.Status))
 	}
 	if len(message.Answer) < 1 {
-		return dto.Record{}, errors.New("no answer in response")
+		return nil, client.Negative(client.ErrNoData, message.authority())
 	}
-	if message.Answer[0].Type == 5 {
-		record, err := c.resolve(message.Answer[0].Data, t)
-		record.Name = name // Keep the Answer consistent with the initial Question
-		return record, err
+
+	records := make([]dto.Record, 0, len(message.Answer))
+	for _, answer := range message.Answer {
+		if answer.Type != uint16(dto.CNAME) && answer.Type != uint16(t) {
+			// records of other types, like DNAME or RRSIG, come along the answer and are not part of it
+			continue
+		}
+		record, err := answer.ToRecord()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
 	}
-	if message.Answer[0].Type != uint16(dto.A) && message.Answer[0].Type != uint16(dto.AAAA) {
-		log.Println("receive message of type", message.Answer[0].Type)
-		return dto.Record{}, errors.New("answer with unknown type in response")
+	if len(records) == 0 {
+		return nil, client.Negative(client.ErrNoData, message.authority())
 	}
 
-	return message.Answer[0].ToRecord(), nil
+	return records, nil
 }
@@ -1,6 +1,10 @@
 package doh
 
 import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
@@ -31,13 +35,16 @@ func TestDOHClient_ResolveV4(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewDOHClient(tt.fields.endpoint)
+			c, err := NewDOHClient(Config{Endpoint: tt.fields.endpoint})
+			if err != nil {
+				t.Fatal(err)
+			}
 			got, err := c.ResolveV4(tt.args.name)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DOHClient.ResolveV4() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if tt.wantEmpty && !reflect.DeepEqual(got, dto.Record{}) {
+			if tt.wantEmpty && len(got) != 0 {
 				t.Errorf("DOHClient.ResolveV4() = %v, want empty", got)
 			}
 		})
@@ -68,15 +75,94 @@ func TestDOHClient_ResolveV6(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewDOHClient(tt.fields.endpoint)
+			c, err := NewDOHClient(Config{Endpoint: tt.fields.endpoint})
+			if err != nil {
+				t.Fatal(err)
+			}
 			got, err := c.ResolveV6(tt.args.name)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DOHClient.ResolveV4() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if tt.wantEmpty && !reflect.DeepEqual(got, dto.Record{}) {
+			if tt.wantEmpty && len(got) != 0 {
 				t.Errorf("DOHClient.ResolveV4() = %v, want empty", got)
 			}
 		})
 	}
 }
+
+func TestDOHClient_OtherTypes(t *testing.T) {
+	// the DNAME and its signature come along the answer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/dns-json")
+		_, _ = w.Write([]byte(`{"Status":0,"Answer":[` +
+			`{"name":"example.com.","type":39,"TTL":60,"data":"example.net."},` +
+			`{"name":"example.com.","type":46,"TTL":60,"data":"DNAME 13 2 60 20300101000000 20200101000000 1 example.com. c2lnbmF0dXJl"},` +
+			`{"name":"www.example.com.","type":1,"TTL":60,"data":"192.0.2.1"}]}`))
+	}))
+	defer server.Close()
+	c, err := NewDOHClient(Config{Endpoint: server.URL + "/dns-query"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Resolve(context.Background(), dto.Question{Name: "www.example.com", Type: dto.A, Class: dto.IN})
+	want := []dto.Record{{Name: "www.example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{192, 0, 2, 1}}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DOHClient.Resolve() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestAnswer_ToRecordGeneric(t *testing.T) {
+	answer := Answer{Name: "example.com.", Type: uint16(dto.HTTPS), Ttl: 300, Data: `\# 7 0001 00 0001 0000`}
+	record, err := answer.ToRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dto.RawData{0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00}
+	if !bytes.Equal(record.Data.(dto.RawData), want) {
+		t.Errorf("Answer.ToRecord() data = %v, want %v", record.Data, want)
+	}
+	if record.Name != "example.com" || record.Type != dto.HTTPS {
+		t.Errorf("Answer.ToRecord() = %v", record)
+	}
+}
+
+func TestAnswer_ToRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  Answer
+		want    dto.RData
+		wantErr bool
+	}{
+		{name: "A", answer: Answer{Type: uint16(dto.A), Data: "192.0.2.1"}, want: dto.IPData{192, 0, 2, 1}},
+		{name: "AAAA", answer: Answer{Type: uint16(dto.AAAA), Data: "2001:db8::1"}, want: dto.IPData{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{name: "MX", answer: Answer{Type: uint16(dto.MX), Data: "10 mx.example.com."}, want: dto.MXData{Preference: 10, Exchange: "mx.example.com"}},
+		{name: "SRV", answer: Answer{Type: uint16(dto.SRV), Data: "10 60 5060 sip.example.com."}, want: dto.SRVData{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com"}},
+		{name: "TXT", answer: Answer{Type: uint16(dto.TXT), Data: `"v=spf1 -all"`}, want: dto.TXTData{"v=spf1 -all"}},
+		{name: "TXT strings", answer: Answer{Type: uint16(dto.TXT), Data: `"first" second  "third one"`}, want: dto.TXTData{"first", "second", "third one"}},
+		{name: "TXT escapes", answer: Answer{Type: uint16(dto.TXT), Data: `"a\"b\\c\065\010\255"`}, want: dto.TXTData{"a\"b\\cA\n\xff"}},
+		{name: "TXT unterminated", answer: Answer{Type: uint16(dto.TXT), Data: `"v=spf1`}, wantErr: true},
+		{name: "TXT escaped octet out of range", answer: Answer{Type: uint16(dto.TXT), Data: `"\256"`}, wantErr: true},
+		{name: "invalid A", answer: Answer{Type: uint16(dto.A), Data: "192.0.2"}, wantErr: true},
+		{name: "ipv6 A", answer: Answer{Type: uint16(dto.A), Data: "2001:db8::1"}, wantErr: true},
+		{name: "ipv4 AAAA", answer: Answer{Type: uint16(dto.AAAA), Data: "192.0.2.1"}, wantErr: true},
+		{name: "MX without exchange", answer: Answer{Type: uint16(dto.MX), Data: "10"}, wantErr: true},
+		{name: "MX with invalid preference", answer: Answer{Type: uint16(dto.MX), Data: "high mx.example.com."}, wantErr: true},
+		{name: "SRV with invalid port", answer: Answer{Type: uint16(dto.SRV), Data: "10 60 99999 sip.example.com."}, wantErr: true},
+		{name: "SOA with missing fields", answer: Answer{Type: uint16(dto.SOA), Data: "ns.example.com. noc.example.com. 1 2 3"}, wantErr: true},
+		{name: "HTTPS presentation", answer: Answer{Type: uint16(dto.HTTPS), Data: `1 . alpn="h2,h3"`}, wantErr: true},
+		{name: "invalid generic length", answer: Answer{Type: uint16(dto.HTTPS), Data: `\# 3 0001`}, wantErr: true},
+		{name: "unknown type", answer: Answer{Type: 99, Data: "some text"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := tt.answer.ToRecord()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Answer.ToRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(record.Data, tt.want) {
+				t.Errorf("Answer.ToRecord() data = %#v, want %#v", record.Data, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package doh
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+)
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultIdleTimeout = 90 * time.Second
+	// maxBodySize maximum size of a response read from the server
+	maxBodySize = 1 << 20
+)
+
+// Config of the dns over https clients
+type Config struct {
+	// Endpoint url of the server
+	Endpoint string
+	// Bootstrap addresses dialed instead of resolving the host of the endpoint, which is still sent in the tls handshake and verified in the certificate.
+	// The port of the endpoint is used when an address has none
+	Bootstrap []string
+	// Timeout of a request when the query has no deadline, 10 seconds when zero
+	Timeout time.Duration
+	// IdleTimeout how long an idle connection is kept open to be reused, 90 seconds when zero
+	IdleTimeout time.Duration
+	// RootCAs used to verify the certificate, the system pool is used when nil
+	RootCAs *x509.CertPool
+}
+
+// newHTTPClient returns a client keeping its connections open, http/2 is negotiated when the server supports it
+func newHTTPClient(conf Config) (*http.Client, error) {
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "https" && endpoint.Scheme != "http" {
+		return nil, errors.New("invalid dns over https endpoint " + conf.Endpoint)
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     &tls.Config{RootCAs: conf.RootCAs, MinVersion: tls.VersionTLS12},
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     conf.IdleTimeout,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+	if transport.IdleConnTimeout == 0 {
+		transport.IdleConnTimeout = defaultIdleTimeout
+	}
+	if len(conf.Bootstrap) > 0 {
+		port := endpoint.Port()
+		if port == "" {
+			port = "443"
+			if endpoint.Scheme == "http" {
+				port = "80"
+			}
+		}
+		transport.DialContext = bootstrapDial(dialer, conf.Bootstrap, port)
+	}
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// bootstrapDial dials the bootstrap addresses in order instead of the address of the request
+func bootstrapDial(dialer *net.Dialer, addresses []string, port string) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network string, _ string) (net.Conn, error) {
+		errs := make([]error, 0, len(addresses))
+		for _, address := range addresses {
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				address = net.JoinHostPort(address, port)
+			}
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// fetch sends the request and returns the body of the successful response, an empty contentType accepts any content
+func fetch(httpClient *http.Client, req *http.Request, contentType string) ([]byte, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, client.TransportFailure(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, client.TransportFailure(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, client.TransportFailure(&client.HTTPStatusError{StatusCode: resp.StatusCode})
+	}
+	if received := resp.Header.Get("content-type"); !strings.HasPrefix(received, contentType) {
+		return nil, errors.New("unexpected content type " + received)
+	}
+	return body, nil
+}
+
+func querySeparator(endpoint string) string {
+	if strings.Contains(endpoint, "?") {
+		return "&"
+	}
+	return "?"
+}
@@ -0,0 +1,65 @@
+package doh
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ client.Client = &WireClient{}
+
+const dnsMessageType = "application/dns-message"
+
+// WireClient Dns Over Http client sending dns messages in wire format, see rfc8484
+type WireClient struct {
+	endpoint   string
+	post       bool
+	httpClient *http.Client
+}
+
+// NewWireClient instantiate a new WireClient, the queries are sent with POST requests when post is true, with GET requests otherwise
+func NewWireClient(conf Config, post bool) (*WireClient, error) {
+	httpClient, err := newHTTPClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &WireClient{
+		endpoint:   conf.Endpoint,
+		post:       post,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Resolve implements client.Client
+func (c *WireClient) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, error) {
+	// the id is always 0 so that the http caches see the same request for the same question, see rfc8484 section 4.1
+	payload := dto.SerializeMessage(client.NewQuery(0, question))
+	var req *http.Request
+	var err error
+	if c.post {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(payload))
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+querySeparator(c.endpoint)+"dns="+base64.RawURLEncoding.EncodeToString(payload), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("accept", dnsMessageType)
+	if c.post {
+		req.Header.Set("content-type", dnsMessageType)
+	}
+
+	body, err := fetch(c.httpClient, req, dnsMessageType)
+	if err != nil {
+		return nil, err
+	}
+	response, err := dto.ParseMessage(body)
+	if err != nil {
+		return nil, err
+	}
+	return client.Answer(response)
+}
@@ -0,0 +1,167 @@
+package doh
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// wireHandler answers localhost with 127.0.0.1 and every other name with NXDOMAIN
+func wireHandler(t *testing.T, method string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			t.Errorf("unexpected method %s", r.Method)
+		}
+		var payload []byte
+		var err error
+		if r.Method == http.MethodGet {
+			payload, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		} else {
+			payload, err = io.ReadAll(r.Body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query, err := dto.ParseMessage(payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response := dto.Message{ID: query.ID, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: query.Question}
+		if query.Question[0].Name == "localhost" {
+			response.ResponseCount = 1
+			response.Response = []dto.Record{{Name: "localhost", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{127, 0, 0, 1}}}
+		} else {
+			response.Header.SetRCode(dto.NXDOMAIN)
+		}
+		w.Header().Set("content-type", dnsMessageType)
+		_, _ = w.Write(dto.SerializeMessage(response))
+	}
+}
+
+func TestWireClient_Resolve(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		t.Run(method, func(t *testing.T) {
+			server := httptest.NewServer(wireHandler(t, method))
+			defer server.Close()
+			c, err := NewWireClient(Config{Endpoint: server.URL + "/dns-query"}, method == http.MethodPost)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := c.Resolve(context.Background(), dto.Question{Name: "localhost", Type: dto.A, Class: dto.IN})
+			want := []dto.Record{{Name: "localhost", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{127, 0, 0, 1}}}
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("WireClient.Resolve() = %v, %v, want %v", got, err, want)
+			}
+
+			_, err = c.Resolve(context.Background(), dto.Question{Name: "unknown", Type: dto.A, Class: dto.IN})
+			if !errors.Is(err, client.ErrNameError) {
+				t.Errorf("WireClient.Resolve() error = %v, want %v", err, client.ErrNameError)
+			}
+		})
+	}
+}
+
+func TestWireClient_ResolveHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	c, err := NewWireClient(Config{Endpoint: server.URL}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Resolve(context.Background(), dto.Question{Name: "localhost", Type: dto.A, Class: dto.IN})
+	var statusErr *client.HTTPStatusError
+	if !errors.Is(err, client.ErrHTTPStatus) || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("WireClient.Resolve() error = %v, want the http status 404", err)
+	}
+}
+
+func TestWireClient_ResolveUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		want     error
+	}{
+		{name: "timeout", endpoint: server.URL, want: client.ErrTimeout},
+		{name: "refused", endpoint: closed.URL, want: client.ErrRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			c, err := NewWireClient(Config{Endpoint: tt.endpoint}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.Resolve(ctx, dto.Question{Name: "localhost", Type: dto.A, Class: dto.IN})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("WireClient.Resolve() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWireClient_ResolveBootstrap(t *testing.T) {
+	protocols := make(chan int, 2)
+	handler := wireHandler(t, http.MethodGet)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocols <- r.ProtoMajor
+		handler(w, r)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	tests := []struct {
+		name      string
+		bootstrap []string
+		roots     *x509.CertPool
+		want      error
+	}{
+		// the certificate of the test server is valid for example.com, which is only reachable through the bootstrap address
+		{name: "bootstrap", bootstrap: []string{"127.0.0.1"}, roots: roots},
+		{name: "unknown authority", bootstrap: []string{"127.0.0.1"}, want: client.ErrTLS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewWireClient(Config{Endpoint: "https://example.com:" + port + "/dns-query", Bootstrap: tt.bootstrap, RootCAs: tt.roots}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err = c.Resolve(ctx, dto.Question{Name: "localhost", Type: dto.A, Class: dto.IN})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("WireClient.Resolve() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil {
+				if protocol := <-protocols; protocol != 2 {
+					t.Errorf("expecting http/2, got http/%d", protocol)
+				}
+			}
+		})
+	}
+}
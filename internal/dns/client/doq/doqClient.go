@@ -0,0 +1,199 @@
+package doq
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/quic-go/quic-go"
+)
+
+var _ client.Client = &DOQClient{}
+
+const defaultPort = "853"
+
+// defaultIdleTimeout is used when the configuration does not set any idle timeout
+const defaultIdleTimeout = 30 * time.Second
+
+// error codes of dns over quic, see rfc9250 section 4.3
+const (
+	doqNoError          = 0x0
+	doqRequestCancelled = 0x3
+)
+
+// alpn token of dns over quic, see rfc9250 section 4.1.1
+var alpn = []string{"doq"}
+
+// Config of a DOQClient
+type Config struct {
+	// Address of the server, the port 853 is used when missing
+	Address string
+	// ServerName verified in the certificate, the host of the address is used when empty
+	ServerName string
+	// RootCAs used to verify the certificate, the system pool is used when nil
+	RootCAs *x509.CertPool
+	// IdleTimeout after which the unused connection is closed, 30 seconds when zero
+	IdleTimeout time.Duration
+}
+
+// DOQClient Dns Over Quic client, each query is sent on its own stream of a persistent quic connection, see rfc9250
+type DOQClient struct {
+	address    string
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+
+	lock sync.Mutex
+	conn *quic.Conn
+}
+
+// NewDOQClient instantiate a new DOQClient, the connection is opened by the first query
+func NewDOQClient(conf Config) (*DOQClient, error) {
+	address := conf.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	serverName := conf.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	idleTimeout := conf.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	return &DOQClient{
+		address: address,
+		tlsConfig: &tls.Config{
+			ServerName: serverName,
+			RootCAs:    conf.RootCAs,
+			NextProtos: alpn,
+			MinVersion: tls.VersionTLS13,
+		},
+		quicConfig: &quic.Config{MaxIdleTimeout: idleTimeout},
+	}, nil
+}
+
+// Resolve implements client.Client
+func (c *DOQClient) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, client.TransportFailure(cryptoFailure(err))
+	}
+	response, err := exchange(ctx, conn, question)
+	if err != nil && conn.Context().Err() != nil && ctx.Err() == nil {
+		// the connection was closed, by the server or after being idle, retry once on a new connection
+		c.forget(conn)
+		if conn, err = c.connection(ctx); err == nil {
+			response, err = exchange(ctx, conn, question)
+		}
+	}
+	if err != nil {
+		return nil, client.TransportFailure(cryptoFailure(err))
+	}
+	return client.Answer(response)
+}
+
+// Close the current connection, the next query opens a new one
+func (c *DOQClient) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn != nil {
+		_ = c.conn.CloseWithError(doqNoError, "")
+		c.conn = nil
+	}
+}
+
+// connection returns the current connection, a new one is opened if there is none or if it is closed
+func (c *DOQClient) connection(ctx context.Context) (*quic.Conn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn != nil && c.conn.Context().Err() == nil {
+		return c.conn, nil
+	}
+	conn, err := quic.DialAddr(ctx, c.address, c.tlsConfig, c.quicConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// forget the connection if it is still the current one
+func (c *DOQClient) forget(conn *quic.Conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+}
+
+// exchange sends the query on a new stream and reads the response on the same stream, see rfc9250 section 4.2
+func exchange(ctx context.Context, conn *quic.Conn, question dto.Question) (*dto.Message, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelWrite(doqRequestCancelled)
+		stream.CancelRead(doqRequestCancelled)
+	})
+	defer stop()
+
+	// the id is always 0, the stream identifies the query, see rfc9250 section 4.2.1
+	query := client.NewQuery(0, question)
+	payload := dto.AppendMessage(make([]byte, 2, dto.ClassicUDPLength), &query)
+	binary.BigEndian.PutUint16(payload, uint16(len(payload)-2))
+	if _, err := stream.Write(payload); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	// the client closes its side of the stream once the query is sent
+	if err := stream.Close(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	buffer := make([]byte, dto.BufferMaxLength)
+	if _, err := io.ReadFull(stream, buffer[:2]); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	length := binary.BigEndian.Uint16(buffer[:2])
+	if _, err := io.ReadFull(stream, buffer[:length]); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	response, err := dto.ParseMessage(buffer[:length])
+	if err != nil {
+		return nil, err
+	}
+	if !client.SameQuestion(response, query.Question[0]) {
+		return nil, errors.New("the response does not match the question " + question.Name)
+	}
+	return response, nil
+}
+
+// contextError returns the error of the context when the stream failed because the query was cancelled
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// cryptoFailure marks the errors of the quic handshake as tls failures, the server closes the connection with a crypto error code
+func cryptoFailure(err error) error {
+	var transportErr *quic.TransportError
+	if errors.As(err, &transportErr) && transportErr.ErrorCode.IsCryptoError() {
+		return fmt.Errorf("%w: %w", client.ErrTLS, err)
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package doq
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/clienttest"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/quic-go/quic-go"
+)
+
+// quicServer is a dns over quic server answering every A question with 127.0.0.1
+type quicServer struct {
+	*clienttest.Server
+	listener *quic.Listener
+	streams  atomic.Int32
+}
+
+func newQUICServer(t *testing.T) *quicServer {
+	server, err := clienttest.NewServer(tls.Certificate{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &quicServer{Server: server}
+	listener, err := quic.ListenAddr("127.0.0.1:0", s.TLSConfig(alpn...), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.listener = listener
+	go s.serve()
+	t.Cleanup(func() { _ = listener.Close() })
+	return s
+}
+
+func (s *quicServer) serve() {
+	for {
+		conn, err := s.listener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		s.Accepted(func() { _ = conn.CloseWithError(doqNoError, "") })
+		go s.handle(conn)
+	}
+}
+
+func (s *quicServer) handle(conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		s.streams.Add(1)
+		go func() {
+			defer stream.Close()
+			// the client closes its side of the stream, the query is read until the end
+			payload, err := io.ReadAll(stream)
+			if err != nil || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != len(payload)-2 {
+				stream.CancelWrite(0x2)
+				return
+			}
+			query, err := dto.ParseMessage(payload[2:])
+			if err != nil || query.ID != 0 {
+				stream.CancelWrite(0x2)
+				return
+			}
+			_, _ = stream.Write(clienttest.Answer(query))
+		}()
+	}
+}
+
+func TestDOQClient_Resolve(t *testing.T) {
+	server := newQUICServer(t)
+
+	tests := []struct {
+		name    string
+		conf    Config
+		wantErr bool
+	}{
+		{name: "server name", conf: Config{ServerName: "localhost", RootCAs: server.Roots()}},
+		{name: "wrong name", conf: Config{ServerName: "example.com", RootCAs: server.Roots()}, wantErr: true},
+		{name: "unknown authority", conf: Config{ServerName: "localhost"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.Address = server.listener.Addr().String()
+			c, err := NewDOQClient(tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			records, err := c.Resolve(ctx, dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DOQClient.Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, client.ErrTLS) {
+				t.Fatalf("DOQClient.Resolve() error = %v, want %v", err, client.ErrTLS)
+			}
+			if !tt.wantErr && (len(records) != 1 || records[0].Data.String() != "127.0.0.1") {
+				t.Fatalf("DOQClient.Resolve() = %v", records)
+			}
+		})
+	}
+}
+
+func TestDOQClient_StreamPerQuery(t *testing.T) {
+	server := newQUICServer(t)
+	c, err := NewDOQClient(Config{Address: server.listener.Addr().String(), ServerName: "localhost", RootCAs: server.Roots()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const queries = 10
+	wg := sync.WaitGroup{}
+	for i := 0; i < queries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			records, err := c.Resolve(context.Background(), dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN})
+			if err != nil || len(records) != 1 {
+				t.Errorf("DOQClient.Resolve() = %v, %v", records, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := server.streams.Load(); n != queries {
+		t.Errorf("expecting a stream per query, got %d streams for %d queries", n, queries)
+	}
+	if n := server.Connections(); n != 1 {
+		t.Errorf("expecting the queries on a single connection, got %d connections", n)
+	}
+}
+
+func TestDOQClient_Reconnect(t *testing.T) {
+	server := newQUICServer(t)
+	c, err := NewDOQClient(Config{Address: server.listener.Addr().String(), ServerName: "localhost", RootCAs: server.Roots()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	question := dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}
+	if _, err := c.Resolve(context.Background(), question); err != nil {
+		t.Fatal(err)
+	}
+
+	server.CloseAll()
+
+	if _, err := c.Resolve(context.Background(), question); err != nil {
+		t.Fatalf("DOQClient.Resolve() after the connection is closed error = %v", err)
+	}
+	if n := server.Connections(); n != 2 {
+		t.Errorf("expecting a new connection, got %d connections", n)
+	}
+}
@@ -0,0 +1,104 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/stream"
+)
+
+var _ client.Client = &DOTClient{}
+
+const defaultPort = "853"
+
+// Config of a DOTClient
+type Config struct {
+	// Address of the server, the port 853 is used when missing
+	Address string
+	// ServerName sent in the tls handshake and verified in the certificate, the name is not verified when empty
+	ServerName string
+	// Pins base64 sha256 digests of the accepted SubjectPublicKeyInfo, any key is accepted when empty.
+	// A key of the verified chain is pinned, without ServerName only the key of the server certificate can be pinned
+	Pins []string
+	// RootCAs used to verify the certificate, the system pool is used when nil
+	RootCAs *x509.CertPool
+}
+
+// DOTClient Dns Over Tls client, the queries are pipelined on a persistent tls connection, see rfc7858
+type DOTClient struct {
+	*stream.Client
+}
+
+// NewDOTClient instantiate a new DOTClient, the server must at least be authenticated by its name or by a pinned key
+func NewDOTClient(conf Config) (*DOTClient, error) {
+	tlsConfig, err := buildTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	address := conf.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+	dialer := &tls.Dialer{Config: tlsConfig}
+	return &DOTClient{
+		Client: stream.NewClient(func(ctx context.Context) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", address)
+		}),
+	}, nil
+}
+
+func buildTLSConfig(conf Config) (*tls.Config, error) {
+	if conf.ServerName == "" && len(conf.Pins) == 0 {
+		return nil, errors.New("dns over tls server " + conf.Address + " needs a server name or pinned keys to be authenticated")
+	}
+	pins := make([][]byte, 0, len(conf.Pins))
+	for _, pin := range conf.Pins {
+		digest, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, errors.New("invalid pin " + pin + ", expecting a base64 sha256 digest")
+		}
+		pins = append(pins, digest)
+	}
+	tlsConfig := &tls.Config{
+		ServerName: conf.ServerName,
+		RootCAs:    conf.RootCAs,
+		MinVersion: tls.VersionTLS12,
+		// without a name, the pinned keys are the only authentication of the server, see rfc8310 section 7.1
+		InsecureSkipVerify: conf.ServerName == "",
+	}
+	if len(pins) > 0 {
+		tlsConfig.VerifyConnection = verifyPins(pins, !tlsConfig.InsecureSkipVerify)
+	}
+	return tlsConfig, nil
+}
+
+// verifyPins accepts the connections whose certificate chain contains one of the pinned keys.
+// The chains verified from the RootCAs are searched when the certificate is verified, otherwise only the leaf certificate
+// is trusted: the other certificates sent by the server are not authenticated and anyone can send a public certificate
+func verifyPins(pins [][]byte, verified bool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		chains := state.VerifiedChains
+		if !verified && len(state.PeerCertificates) > 0 {
+			chains = [][]*x509.Certificate{state.PeerCertificates[:1]}
+		}
+		for _, chain := range chains {
+			for _, cert := range chain {
+				digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if bytes.Equal(digest[:], pin) {
+						return nil
+					}
+				}
+			}
+		}
+		return fmt.Errorf("%w: no certificate of the server matches the pinned keys", client.ErrTLS)
+	}
+}
@@ -0,0 +1,238 @@
+package dot
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/clienttest"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// tlsServer is a dns over tls server answering every A question with 127.0.0.1,
+// the questions on slow.test are answered after the others
+type tlsServer struct {
+	*clienttest.Server
+	listener net.Listener
+}
+
+// newTLSServer starts a server sending the certificate chain, a self signed certificate when the chain is empty
+func newTLSServer(t *testing.T, certificate tls.Certificate) *tlsServer {
+	server, err := clienttest.NewServer(certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &tlsServer{Server: server}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", s.TLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.listener = listener
+	go s.serve()
+	t.Cleanup(func() { _ = listener.Close() })
+	return s
+}
+
+// newCertificate returns a certificate of localhost signed by the issuer, it is self signed when the issuer is nil
+func newCertificate(t *testing.T, issuer *tls.Certificate, isCA bool) tls.Certificate {
+	t.Helper()
+	res, err := clienttest.Certificate(issuer, isCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func (s *tlsServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.Accepted(func() { _ = conn.Close() })
+		go s.handle(conn)
+	}
+}
+
+func (s *tlsServer) handle(conn net.Conn) {
+	defer conn.Close()
+	writeLock := sync.Mutex{}
+	for {
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		query, err := dto.ParseMessage(payload)
+		if err != nil {
+			return
+		}
+		go func() {
+			if query.Question[0].Name == "slow.test" {
+				time.Sleep(100 * time.Millisecond)
+			}
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			_, _ = conn.Write(clienttest.Answer(query))
+		}()
+	}
+}
+
+func TestDOTClient_Resolve(t *testing.T) {
+	server := newTLSServer(t, tls.Certificate{})
+	roots := server.Roots()
+
+	tests := []struct {
+		name    string
+		conf    Config
+		wantErr bool
+	}{
+		{name: "server name", conf: Config{ServerName: "localhost", RootCAs: roots}},
+		{name: "server name and pin", conf: Config{ServerName: "localhost", RootCAs: roots, Pins: []string{server.Pin()}}},
+		{name: "pin only", conf: Config{Pins: []string{server.Pin()}}},
+		{name: "wrong pin", conf: Config{Pins: []string{base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}}, wantErr: true},
+		{name: "wrong name", conf: Config{ServerName: "example.com", RootCAs: roots}, wantErr: true},
+		{name: "unknown authority", conf: Config{ServerName: "localhost"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.Address = server.listener.Addr().String()
+			c, err := NewDOTClient(tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			records, err := c.Resolve(context.Background(), dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DOTClient.Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, client.ErrTLS) {
+				t.Fatalf("DOTClient.Resolve() error = %v, want %v", err, client.ErrTLS)
+			}
+			if !tt.wantErr && (len(records) != 1 || records[0].Data.String() != "127.0.0.1") {
+				t.Fatalf("DOTClient.Resolve() = %v", records)
+			}
+		})
+	}
+}
+
+func TestNewDOTClientUnauthenticated(t *testing.T) {
+	if _, err := NewDOTClient(Config{Address: "127.0.0.1"}); err == nil {
+		t.Fatalf("NewDOTClient() expecting an error without server name nor pin")
+	}
+	if _, err := NewDOTClient(Config{Address: "127.0.0.1", Pins: []string{"invalid"}}); err == nil {
+		t.Fatalf("NewDOTClient() expecting an error with an invalid pin")
+	}
+}
+
+func TestDOTClient_Pipelining(t *testing.T) {
+	server := newTLSServer(t, tls.Certificate{})
+	c, err := NewDOTClient(Config{Address: server.listener.Addr().String(), Pins: []string{server.Pin()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// open the connection
+	if _, err := c.Resolve(context.Background(), dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := make(chan time.Time, 1)
+	go func() {
+		_, err := c.Resolve(context.Background(), dto.Question{Name: "slow.test", Type: dto.A, Class: dto.IN})
+		if err != nil {
+			t.Error(err)
+		}
+		slow <- time.Now()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	records, err := c.Resolve(context.Background(), dto.Question{Name: "fast.test", Type: dto.A, Class: dto.IN})
+	fast := time.Now()
+	if err != nil || records[0].Name != "fast.test" {
+		t.Fatalf("DOTClient.Resolve() = %v, %v", records, err)
+	}
+	if !fast.Before(<-slow) {
+		t.Errorf("the fast query waited for the slow one")
+	}
+	if n := server.Connections(); n != 1 {
+		t.Errorf("expecting the queries on a single connection, got %d connections", n)
+	}
+}
+
+func TestDOTClient_Reconnect(t *testing.T) {
+	server := newTLSServer(t, tls.Certificate{})
+	c, err := NewDOTClient(Config{Address: server.listener.Addr().String(), Pins: []string{server.Pin()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	question := dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}
+	if _, err := c.Resolve(context.Background(), question); err != nil {
+		t.Fatal(err)
+	}
+
+	server.CloseAll()
+
+	if _, err := c.Resolve(context.Background(), question); err != nil {
+		t.Fatalf("DOTClient.Resolve() after the connection is closed error = %v", err)
+	}
+	if n := server.Connections(); n != 2 {
+		t.Errorf("expecting a new connection, got %d connections", n)
+	}
+}
+
+func TestDOTClient_PinnedChain(t *testing.T) {
+	ca := newCertificate(t, nil, true)
+	leaf := newCertificate(t, &ca, false)
+	forged := newCertificate(t, nil, false)
+	roots := clienttest.Roots(ca.Leaf)
+	chain := func(leaf tls.Certificate) tls.Certificate {
+		leaf.Certificate = append(leaf.Certificate, ca.Certificate[0])
+		return leaf
+	}
+
+	tests := []struct {
+		name        string
+		certificate tls.Certificate
+		conf        Config
+		wantErr     bool
+	}{
+		{name: "pinned ca of the verified chain", certificate: chain(leaf), conf: Config{ServerName: "localhost", RootCAs: roots, Pins: []string{clienttest.Pin(ca.Leaf)}}},
+		{name: "pinned leaf", certificate: chain(leaf), conf: Config{Pins: []string{clienttest.Pin(leaf.Leaf)}}},
+		// without verification the ca sent by the server does not authenticate the leaf
+		{name: "pinned ca without verification", certificate: chain(leaf), conf: Config{Pins: []string{clienttest.Pin(ca.Leaf)}}, wantErr: true},
+		{name: "pinned ca appended to a forged leaf", certificate: chain(forged), conf: Config{Pins: []string{clienttest.Pin(ca.Leaf)}}, wantErr: true},
+		{name: "pinned ca appended to a forged leaf with verification", certificate: chain(forged), conf: Config{ServerName: "localhost", RootCAs: roots, Pins: []string{clienttest.Pin(ca.Leaf)}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTLSServer(t, tt.certificate)
+			tt.conf.Address = server.listener.Addr().String()
+			c, err := NewDOTClient(tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			_, err = c.Resolve(context.Background(), dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DOTClient.Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, client.ErrTLS) {
+				t.Fatalf("DOTClient.Resolve() error = %v, want %v", err, client.ErrTLS)
+			}
+		})
+	}
+}
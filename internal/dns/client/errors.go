@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strconv"
+	"syscall"
+)
+
+var (
+	// ErrTimeout is the kind of the transport errors of an upstream which did not answer in time
+	ErrTimeout = errors.New("upstream timeout")
+	// ErrRefused is the kind of the transport errors of an upstream refusing the connection
+	ErrRefused = errors.New("upstream refused the connection")
+	// ErrTLS is the kind of the transport errors of an upstream failing the tls handshake or its authentication
+	ErrTLS = errors.New("upstream tls failure")
+	// ErrHTTPStatus is the kind of the transport errors of an upstream answering with an unsuccessful http status
+	ErrHTTPStatus = errors.New("upstream http failure")
+	// ErrTransport is the kind of the other transport errors
+	ErrTransport = errors.New("upstream transport failure")
+)
+
+var _ error = &TransportError{}
+
+// TransportError is a failure to exchange messages with an upstream, it matches both its Kind and its cause with errors.Is
+type TransportError struct {
+	Kind error
+	Err  error
+}
+
+// Error implements error
+func (e *TransportError) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the kind and the cause of the error
+func (e *TransportError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+var _ error = &HTTPStatusError{}
+
+// HTTPStatusError is returned when an http upstream answers with an unsuccessful status
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+// Error implements error
+func (e *HTTPStatusError) Error() string {
+	return "http status is " + strconv.Itoa(e.StatusCode)
+}
+
+// TransportFailure wraps an error of the exchange with an upstream in a TransportError of the matching kind.
+// The cancellations and the errors already wrapped are returned as is
+func TransportFailure(err error) error {
+	var transportErr *TransportError
+	if err == nil || errors.Is(err, context.Canceled) || errors.As(err, &transportErr) {
+		return err
+	}
+	return &TransportError{Kind: transportKind(err), Err: err}
+}
+
+func transportKind(err error) error {
+	var timeoutErr interface{ Timeout() bool }
+	var statusErr *HTTPStatusError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verificationErr *tls.CertificateVerificationError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &timeoutErr) && timeoutErr.Timeout():
+		return ErrTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrRefused
+	case errors.Is(err, ErrTLS), errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verificationErr):
+		return ErrTLS
+	case errors.As(err, &statusErr):
+		return ErrHTTPStatus
+	default:
+		return ErrTransport
+	}
+}
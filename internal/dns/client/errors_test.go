@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestTransportFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "deadline", err: context.DeadlineExceeded, want: ErrTimeout},
+		{name: "socket timeout", err: &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}, want: ErrTimeout},
+		{name: "refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: ErrRefused},
+		{name: "tls alert", err: fmt.Errorf("handshake: %w", tls.AlertError(40)), want: ErrTLS},
+		{name: "tls authentication", err: fmt.Errorf("%w: unknown key", ErrTLS), want: ErrTLS},
+		{name: "http status", err: &HTTPStatusError{StatusCode: 502}, want: ErrHTTPStatus},
+		{name: "other", err: errors.New("broken pipe"), want: ErrTransport},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TransportFailure(tt.err)
+			if !errors.Is(got, tt.want) || !errors.Is(got, tt.err) {
+				t.Errorf("TransportFailure() = %v, want an error matching %v and %v", got, tt.want, tt.err)
+			}
+			if TransportFailure(got) != got {
+				t.Errorf("TransportFailure() wrapped a transport error again")
+			}
+		})
+	}
+	if err := TransportFailure(context.Canceled); err != context.Canceled {
+		t.Errorf("TransportFailure() = %v, want the cancellation as is", err)
+	}
+	if TransportFailure(nil) != nil {
+		t.Errorf("TransportFailure(nil) != nil")
+	}
+}
@@ -1,7 +1,9 @@
 package inmemoryclient
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
 	"sync"
 
@@ -9,52 +11,98 @@ import (
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
 
-var _ client.Client = &InMemoryClient{}
+var _ client.ReversableClient = &InMemoryClient{}
 
 //Concurrent safe client, storing data in memory
 type InMemoryClient struct {
-	v4Store sync.Map
-	v6Store sync.Map
+	v4Store      sync.Map
+	v6Store      sync.Map
+	reverseStore sync.Map
+	addLock      sync.Mutex
 }
 
-func (c *InMemoryClient) ResolveV4(name string) (dto.Record, error) {
-	ip, ok := c.v4Store.Load(name)
+// Resolve implements client.Client
+// Only addresses and their reverse names are stored, the questions of other types are left to the other sources
+func (c *InMemoryClient) Resolve(_ context.Context, question dto.Question) ([]dto.Record, error) {
+	var store *sync.Map
+	switch question.Type {
+	case dto.A:
+		store = &c.v4Store
+	case dto.AAAA:
+		store = &c.v6Store
+	case dto.PTR:
+		return c.resolvePTR(question)
+	default:
+		return nil, fmt.Errorf("%s for type %d: %w", question.Name, question.Type, client.ErrNotFound)
+	}
+	ips, ok := store.Load(question.Name)
 	if !ok {
-		return dto.Record{}, errors.New(name + " not found for v4")
+		return nil, fmt.Errorf("%s for type %d: %w", question.Name, question.Type, client.ErrNotFound)
+	}
+	return records(question.Name, question.Type, ips.([]net.IP)), nil
+}
+
+// ReverseResolve implements client.ReversableClient
+func (c *InMemoryClient) ReverseResolve(ctx context.Context, ip string) ([]dto.Record, error) {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return nil, errors.New("unknown address format for " + ip)
 	}
-	return dto.Record{
-		Name:  name,
-		Type:  dto.A,
-		Class: dto.IN,
-		TTL:   200,
-		Data:  ip.(net.IP),
-	}, nil
+	return c.Resolve(ctx, dto.Question{Name: dto.ReverseName(address), Type: dto.PTR, Class: dto.IN})
 }
-func (c *InMemoryClient) ResolveV6(name string) (dto.Record, error) {
-	ip, ok := c.v6Store.Load(name)
+
+func (c *InMemoryClient) resolvePTR(question dto.Question) ([]dto.Record, error) {
+	ip, ok := dto.ParseReverseName(question.Name)
+	if !ok {
+		return nil, fmt.Errorf("%s for type %d: %w", question.Name, question.Type, client.ErrNotFound)
+	}
+	names, ok := c.reverseStore.Load(ip.String())
 	if !ok {
-		return dto.Record{}, errors.New(name + " not found for v6")
+		return nil, fmt.Errorf("%s for type %d: %w", question.Name, question.Type, client.ErrNotFound)
 	}
-	return dto.Record{
-		Name:  name,
-		Type:  dto.AAAA,
-		Class: dto.IN,
-		TTL:   200,
-		Data:  ip.(net.IP),
-	}, nil
+	res := make([]dto.Record, 0, len(names.([]string)))
+	for _, name := range names.([]string) {
+		res = append(res, dto.Record{
+			Name:  question.Name,
+			Type:  dto.PTR,
+			Class: dto.IN,
+			TTL:   200,
+			Data:  dto.NameData(name),
+		})
+	}
+	return res, nil
+}
+
+func records(name string, t dto.Type, ips []net.IP) []dto.Record {
+	res := make([]dto.Record, len(ips))
+	for i, ip := range ips {
+		res[i] = dto.Record{
+			Name:  name,
+			Type:  t,
+			Class: dto.IN,
+			TTL:   200,
+			Data:  dto.IPData(ip),
+		}
+	}
+	return res
 }
 
+// Add an address to the name, a name added several times is answered with all its addresses.
+// The reverse entry of the address is added too
 func (c *InMemoryClient) Add(name, address string) error {
+	c.addLock.Lock()
+	defer c.addLock.Unlock()
 	ip := net.ParseIP(address)
 	if !(c.tryAddV4(name, ip) || c.tryAddV6(name, ip)) {
 		return errors.New("unknown address format for " + ip.String())
 	}
+	storeAppend(&c.reverseStore, ip.String(), name)
 	return nil
 }
 
 func (c *InMemoryClient) tryAddV6(name string, ip net.IP) bool {
 	if v6 := ip.To16(); v6 != nil {
-		c.v6Store.Store(name, v6)
+		storeAppend(&c.v6Store, name, v6)
 		return true
 	}
 	return false
@@ -62,8 +110,17 @@ func (c *InMemoryClient) tryAddV6(name string, ip net.IP) bool {
 
 func (c *InMemoryClient) tryAddV4(name string, ip net.IP) bool {
 	if v4 := ip.To4(); v4 != nil {
-		c.v4Store.Store(name, v4)
+		storeAppend(&c.v4Store, name, v4)
 		return true
 	}
 	return false
 }
+
+// storeAppend append the value to the ones of the key, the stored slice is never modified as it may be read concurrently
+func storeAppend[T any](store *sync.Map, key string, value T) {
+	var values []T
+	if old, ok := store.Load(key); ok {
+		values = old.([]T)
+	}
+	store.Store(key, append(values[:len(values):len(values)], value))
+}
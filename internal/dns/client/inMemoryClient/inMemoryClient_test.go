@@ -1,11 +1,14 @@
 package inmemoryclient
 
 import (
+	"context"
+	"errors"
 	"net"
 	"os"
 	"reflect"
 	"testing"
 
+	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
 
@@ -16,6 +19,8 @@ func TestMain(m *testing.M) {
 	c.Add("localhost", "127.0.0.1")  //ipv4
 	c.Add("localhost", "::1")        //ipv6
 	c.Add("unknown", "192897347459") //not an ip
+	c.Add("cdn", "192.0.2.1")
+	c.Add("cdn", "192.0.2.2")
 	os.Exit(m.Run())
 }
 
@@ -27,37 +32,46 @@ func TestInMemoryClient_ResolveV4(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
-		want    dto.Record
+		want    []dto.Record
 		wantErr bool
 	}{
 		{
 			name: "localhost v4",
 			args: args{name: "localhost"},
-			want: dto.Record{
+			want: []dto.Record{{
 				Name:  "localhost",
 				Type:  dto.A,
 				Class: dto.IN,
 				TTL:   200,
-				Data:  net.ParseIP("127.0.0.1").To4(),
+				Data:  dto.IPData(net.ParseIP("127.0.0.1").To4()),
+			}},
+			wantErr: false,
+		},
+		{
+			name: "cdn v4",
+			args: args{name: "cdn"},
+			want: []dto.Record{
+				{Name: "cdn", Type: dto.A, Class: dto.IN, TTL: 200, Data: dto.IPData(net.ParseIP("192.0.2.1").To4())},
+				{Name: "cdn", Type: dto.A, Class: dto.IN, TTL: 200, Data: dto.IPData(net.ParseIP("192.0.2.2").To4())},
 			},
 			wantErr: false,
 		},
 		{
 			name:    "unknown v4",
 			args:    args{name: "unknown"},
-			want:    dto.Record{},
+			want:    nil,
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := c.ResolveV4(tt.args.name)
+			got, err := c.Resolve(context.Background(), dto.Question{Name: tt.args.name, Type: dto.A, Class: dto.IN})
 			if (err != nil) != tt.wantErr {
-				t.Errorf("InMemoryClient.ResolveV4() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("InMemoryClient.Resolve() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("InMemoryClient.ResolveV4() = %v, want %v", got, tt.want)
+				t.Errorf("InMemoryClient.Resolve() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -70,38 +84,63 @@ func TestInMemoryClient_ResolveV6(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
-		want    dto.Record
+		want    []dto.Record
 		wantErr bool
 	}{
 		{
 			name: "localhost v6",
 			args: args{name: "localhost"},
-			want: dto.Record{
+			want: []dto.Record{{
 				Name:  "localhost",
 				Type:  dto.AAAA,
 				Class: dto.IN,
 				TTL:   200,
-				Data:  net.ParseIP("::1").To16(),
-			},
+				Data:  dto.IPData(net.ParseIP("::1").To16()),
+			}},
 			wantErr: false,
 		},
 		{
 			name:    "unknown v6",
 			args:    args{name: "unknown"},
-			want:    dto.Record{},
+			want:    nil,
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := c.ResolveV6(tt.args.name)
+			got, err := c.Resolve(context.Background(), dto.Question{Name: tt.args.name, Type: dto.AAAA, Class: dto.IN})
 			if (err != nil) != tt.wantErr {
-				t.Errorf("InMemoryClient.ResolveV6() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("InMemoryClient.Resolve() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("InMemoryClient.ResolveV6() = %v, want %v", got, tt.want)
+				t.Errorf("InMemoryClient.Resolve() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestInMemoryClient_ResolveOtherType(t *testing.T) {
+	_, err := c.Resolve(context.Background(), dto.Question{Name: "localhost", Type: dto.MX, Class: dto.IN})
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("InMemoryClient.Resolve() error = %v, want %v", err, client.ErrNotFound)
+	}
+}
+
+func TestInMemoryClient_ReverseResolve(t *testing.T) {
+	got, err := c.ReverseResolve(context.Background(), "192.0.2.1")
+	want := []dto.Record{{Name: "1.2.0.192.in-addr.arpa", Type: dto.PTR, Class: dto.IN, TTL: 200, Data: dto.NameData("cdn")}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("InMemoryClient.ReverseResolve() = %v, %v, want %v", got, err, want)
+	}
+
+	got, err = c.Resolve(context.Background(), dto.Question{Name: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa", Type: dto.PTR, Class: dto.IN})
+	if err != nil || len(got) != 1 || got[0].Data != dto.NameData("localhost") {
+		t.Errorf("InMemoryClient.Resolve() = %v, %v, want localhost", got, err)
+	}
+
+	_, err = c.ReverseResolve(context.Background(), "192.0.2.3")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("InMemoryClient.ReverseResolve() error = %v, want %v", err, client.ErrNotFound)
+	}
+}
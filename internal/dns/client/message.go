@@ -0,0 +1,53 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// NewQuery build the message sent to an upstream server to resolve the question
+func NewQuery(id uint16, question dto.Question) dto.Message {
+	question.Name = strings.TrimRight(question.Name, ".")
+	return dto.Message{
+		ID:            id,
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{question},
+	}
+}
+
+// RandomID returns an unpredictable query id, so that forged answers are hard to accept, see rfc5452 section 4.3
+func RandomID() uint16 {
+	var id [2]byte
+	_, _ = rand.Read(id[:])
+	return binary.BigEndian.Uint16(id[:])
+}
+
+// Answer returns the answer set of the response of an upstream server,
+// unsuccessful responses are converted to the matching error, the negative ones keep the SOA of their authority section
+func Answer(response *dto.Message) ([]dto.Record, error) {
+	switch rcode := response.RCode(); rcode {
+	case dto.NOERROR:
+	case dto.NXDOMAIN:
+		return nil, Negative(ErrNameError, response.Authority)
+	default:
+		return nil, errors.New("upstream answered with rcode " + strconv.Itoa(int(rcode)))
+	}
+	if len(response.Response) < 1 {
+		return nil, Negative(ErrNoData, response.Authority)
+	}
+	return response.Response, nil
+}
+
+// SameQuestion returns true when the response answers the question, the names are case insensitive see rfc4343
+func SameQuestion(response *dto.Message, question dto.Question) bool {
+	return len(response.Question) == 1 &&
+		response.Question[0].Type == question.Type &&
+		response.Question[0].Class == question.Class &&
+		strings.EqualFold(response.Question[0].Name, question.Name)
+}
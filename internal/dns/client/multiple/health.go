@@ -0,0 +1,75 @@
+package multiple
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// HealthCheck configures the health checking of the upstreams
+type HealthCheck struct {
+	// Interval between two probes of an upstream
+	Interval time.Duration
+	// Timeout of a probe
+	Timeout time.Duration
+	// Threshold number of consecutive transport failures, of queries or probes, after which an upstream is down
+	Threshold int32
+	// Probe question sent to the upstreams, any answer even negative means the upstream is up
+	Probe dto.Question
+}
+
+// StartHealthCheck probes the upstreams periodically until the context is done.
+// Once the health is checked, the upstreams which can not be reached are marked down and not queried anymore
+// unless every upstream is down, they are queried again once a probe succeeds
+func (c *MultipleClient) StartHealthCheck(ctx context.Context, wg *sync.WaitGroup, conf HealthCheck) {
+	c.threshold.Store(conf.Threshold)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(conf.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.probeAll(ctx, conf)
+			}
+		}
+	}()
+}
+
+func (c *MultipleClient) probeAll(ctx context.Context, conf HealthCheck) {
+	wg := sync.WaitGroup{}
+	for _, u := range c.upstreams {
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, conf.Timeout)
+			defer cancel()
+			_, err := u.Client.Resolve(pctx, conf.Probe)
+			if ctx.Err() == nil {
+				u.report(!unhealthy(err), conf.Threshold)
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+// report the outcome of a query or a probe, threshold is zero when the health is not checked
+func (u *upstream) report(success bool, threshold int32) {
+	if success {
+		u.consecutiveFailures.Store(0)
+		if u.down.CompareAndSwap(true, false) {
+			log.Println("upstream", u.Name, "is up")
+		}
+		return
+	}
+	n := u.consecutiveFailures.Add(1)
+	if threshold > 0 && n >= threshold && u.down.CompareAndSwap(false, true) {
+		log.Println("upstream", u.Name, "is down after", n, "consecutive failures")
+	}
+}
@@ -0,0 +1,151 @@
+package multiple
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// switchClient fails while it is broken
+type switchClient struct {
+	broken atomic.Bool
+	calls  atomic.Int32
+}
+
+// Resolve implements client.Client
+func (s *switchClient) Resolve(_ context.Context, question dto.Question) ([]dto.Record, error) {
+	s.calls.Add(1)
+	if s.broken.Load() {
+		return nil, client.TransportFailure(errors.New("connection reset"))
+	}
+	return []dto.Record{{Name: question.Name, Type: dto.TXT, Class: dto.IN, TTL: 60, Data: dto.TXTData{"switch"}}}, nil
+}
+
+func TestMultipleClient_HealthCheck(t *testing.T) {
+	flaky := &switchClient{}
+	flaky.broken.Store(true)
+	working := &mockClient{name: "working"}
+	c := NewMultipleClient(Failover, 0, Upstream{Name: "flaky", Client: flaky}, Upstream{Name: "working", Client: working})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	defer cancel()
+	c.StartHealthCheck(ctx, &wg, HealthCheck{Interval: 10 * time.Millisecond, Timeout: time.Second, Threshold: 2, Probe: dto.Question{Name: ".", Type: dto.NS, Class: dto.IN}})
+
+	waitFor(t, func() bool { return c.Stats()[0].Down }, "the flaky upstream is never marked down")
+	calls := flaky.calls.Load()
+	for i := 0; i < 5; i++ {
+		if got := resolve(t, c); got != "working" {
+			t.Fatalf("expecting the answer of the working upstream, got %s", got)
+		}
+	}
+	if flaky.calls.Load() > calls+1 { // a probe may run meanwhile
+		t.Fatalf("the queries are still sent to the upstream down")
+	}
+
+	flaky.broken.Store(false)
+	waitFor(t, func() bool { return !c.Stats()[0].Down }, "the flaky upstream is never back")
+	if got := resolve(t, c); got != "switch" {
+		t.Fatalf("expecting the answer of the recovered upstream, got %s", got)
+	}
+}
+
+func TestMultipleClient_AllDown(t *testing.T) {
+	broken := &switchClient{}
+	broken.broken.Store(true)
+	c := NewMultipleClient(Failover, 0, Upstream{Name: "broken", Client: broken})
+	c.threshold.Store(2)
+	question := dto.Question{Name: "example.com", Type: dto.TXT, Class: dto.IN}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Resolve(context.Background(), question); err == nil {
+			t.Fatalf("MultipleClient.Resolve() expecting the error of the upstream")
+		}
+	}
+	if !c.Stats()[0].Down {
+		t.Fatalf("expecting the upstream to be down")
+	}
+
+	// every upstream is down, they are still asked
+	broken.broken.Store(false)
+	if _, err := c.Resolve(context.Background(), question); err != nil {
+		t.Fatalf("MultipleClient.Resolve() error = %v", err)
+	}
+	if n := broken.calls.Load(); n != 3 {
+		t.Fatalf("expecting the upstream down to be queried, got %d queries", n)
+	}
+	if c.Stats()[0].Down {
+		t.Fatalf("expecting the upstream answering to be up")
+	}
+}
+
+// answerClient answers with an error which is not a transport failure
+type answerClient struct {
+	err error
+}
+
+// Resolve implements client.Client
+func (a answerClient) Resolve(ctx context.Context, _ dto.Question) ([]dto.Record, error) {
+	if a.err == nil {
+		<-ctx.Done()
+		return nil, client.TransportFailure(ctx.Err())
+	}
+	return nil, a.err
+}
+
+func TestMultipleClient_HealthyFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "servfail", err: errors.New("upstream answered with rcode 2")},
+		{name: "refused", err: errors.New("upstream answered with rcode 5")},
+		{name: "query deadline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewMultipleClient(Failover, 0, Upstream{Name: "answering", Client: answerClient{err: tt.err}})
+			c.threshold.Store(2)
+			for i := 0; i < 5; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+				_, err := c.Resolve(ctx, dto.Question{Name: "lame.example.com", Type: dto.A, Class: dto.IN})
+				cancel()
+				if err == nil {
+					t.Fatalf("MultipleClient.Resolve() expecting an error")
+				}
+			}
+			if stats := c.Stats()[0]; stats.Down || stats.ConsecutiveFailures != 0 {
+				t.Fatalf("the upstream answering must not be down, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestMultipleClient_NoHealthCheck(t *testing.T) {
+	broken := &switchClient{}
+	broken.broken.Store(true)
+	c := NewMultipleClient(Failover, 0, Upstream{Name: "broken", Client: broken})
+	for i := 0; i < 10; i++ {
+		_, _ = c.Resolve(context.Background(), dto.Question{Name: "example.com", Type: dto.TXT, Class: dto.IN})
+	}
+	if stats := c.Stats()[0]; stats.Down || stats.ConsecutiveFailures != 10 {
+		t.Fatalf("without health checking the upstream must not be down, got %+v", stats)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
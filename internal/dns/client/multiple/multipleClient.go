@@ -0,0 +1,246 @@
+package multiple
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ client.Client = &MultipleClient{}
+
+// Strategy is the way the upstreams are chosen to resolve a question
+type Strategy string
+
+const (
+	// Failover asks the upstreams in order, the next one is asked when one fails
+	Failover Strategy = "failover"
+	// RoundRobin starts with the next upstream at each query, failing over to the following ones
+	RoundRobin Strategy = "round_robin"
+	// LowestLatency starts with the upstream having the lowest round trip time, failing over to the slower ones
+	LowestLatency Strategy = "lowest_latency"
+	// Race asks the first upstreams at the same time and keep the fastest answer, failing over to the other upstreams
+	Race Strategy = "race"
+)
+
+// failurePenalty is the round trip time accounted for a failed query, so that failing upstreams are the slowest
+const failurePenalty = time.Second
+
+// errShareExpired is the cause of an attempt cancelled because the upstream used its share of the deadline of the query
+var errShareExpired = errors.New("upstream did not answer in its share of the deadline")
+
+// Upstream is a named client
+type Upstream struct {
+	Name   string
+	Client client.Client
+}
+
+// Stats of the queries sent to an upstream
+type Stats struct {
+	Name     string
+	Queries  uint64
+	Failures uint64
+	// RTT is the smoothed round trip time, see rfc6298
+	RTT                 time.Duration
+	ConsecutiveFailures int32
+	Down                bool
+}
+
+type upstream struct {
+	Upstream
+	queries             atomic.Uint64
+	failures            atomic.Uint64
+	rtt                 atomic.Int64
+	consecutiveFailures atomic.Int32
+	down                atomic.Bool
+}
+
+// MultipleClient resolves the questions with several upstreams chosen by its strategy
+type MultipleClient struct {
+	upstreams []*upstream
+	strategy  Strategy
+	race      int
+	next      atomic.Uint32
+	threshold atomic.Int32
+}
+
+// NewMultipleClient instantiate a MultipleClient, race is the number of upstreams raced by the Race strategy
+func NewMultipleClient(strategy Strategy, race int, upstreams ...Upstream) *MultipleClient {
+	res := &MultipleClient{
+		upstreams: make([]*upstream, 0, len(upstreams)),
+		strategy:  strategy,
+		race:      max(1, min(race, len(upstreams))),
+	}
+	for _, u := range upstreams {
+		res.upstreams = append(res.upstreams, &upstream{Upstream: u})
+	}
+	return res
+}
+
+// Resolve implements client.Client
+func (c *MultipleClient) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, error) {
+	if len(c.upstreams) == 0 {
+		return nil, errors.New("no upstream configured")
+	}
+	order := c.order()
+	if c.strategy == Race {
+		race := min(c.race, len(order))
+		// the race is a single attempt, the upstreams left after it get their share of the deadline
+		attempt, cancel := share(ctx, len(order)-race+1)
+		records, err := c.raceFirst(attempt, question, order[:race])
+		cancel()
+		if !failed(err) {
+			return records, err
+		}
+		order = order[race:]
+		if len(order) == 0 {
+			return nil, err
+		}
+	}
+	return c.failover(ctx, question, order)
+}
+
+// Stats returns the stats of every upstream
+func (c *MultipleClient) Stats() []Stats {
+	res := make([]Stats, 0, len(c.upstreams))
+	for _, u := range c.upstreams {
+		res = append(res, Stats{
+			Name:                u.Name,
+			Queries:             u.queries.Load(),
+			Failures:            u.failures.Load(),
+			RTT:                 time.Duration(u.rtt.Load()),
+			ConsecutiveFailures: u.consecutiveFailures.Load(),
+			Down:                u.down.Load(),
+		})
+	}
+	return res
+}
+
+// order returns the upstreams in the order they are asked, the upstreams down are not asked.
+// When every upstream is down they are all asked anyway, the health may be wrong and failing immediately would not help
+func (c *MultipleClient) order() []*upstream {
+	order := make([]*upstream, 0, len(c.upstreams))
+	for _, u := range c.upstreams {
+		if !u.down.Load() {
+			order = append(order, u)
+		}
+	}
+	if len(order) == 0 {
+		order = append(order, c.upstreams...)
+	}
+	switch c.strategy {
+	case RoundRobin:
+		start := int((c.next.Add(1) - 1) % uint32(len(order)))
+		order = append(order[start:], order[:start]...)
+	case LowestLatency:
+		// the upstreams never measured have a zero rtt, they are asked first to be measured
+		slices.SortStableFunc(order, func(a, b *upstream) int {
+			return cmp.Compare(a.rtt.Load(), b.rtt.Load())
+		})
+	}
+	return order
+}
+
+func (c *MultipleClient) failover(ctx context.Context, question dto.Question, order []*upstream) ([]dto.Record, error) {
+	var errs []error
+	for i, u := range order {
+		attempt, cancel := share(ctx, len(order)-i)
+		records, err := c.resolve(attempt, u, question)
+		cancel()
+		if !failed(err) {
+			return records, err
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// share returns the context of an attempt among the remaining ones, it gets its share of the time left before the deadline of the query
+// so that an upstream not answering leaves some time to the next ones
+func share(ctx context.Context, remaining int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, time.Until(deadline)/time.Duration(remaining), errShareExpired)
+}
+
+// raceFirst asks all the upstreams at the same time, the first successful answer is returned and the other queries are cancelled
+func (c *MultipleClient) raceFirst(ctx context.Context, question dto.Question, upstreams []*upstream) ([]dto.Record, error) {
+	type result struct {
+		records []dto.Record
+		err     error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(upstreams))
+	for _, u := range upstreams {
+		go func(u *upstream) {
+			records, err := c.resolve(ctx, u, question)
+			results <- result{records: records, err: err}
+		}(u)
+	}
+	errs := make([]error, 0, len(upstreams))
+	for range upstreams {
+		r := <-results
+		if !failed(r.err) {
+			return r.records, r.err
+		}
+		errs = append(errs, r.err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (c *MultipleClient) resolve(ctx context.Context, u *upstream, question dto.Question) ([]dto.Record, error) {
+	start := time.Now()
+	records, err := u.Client.Resolve(ctx, question)
+	if errors.Is(err, context.Canceled) {
+		return records, err // lost a race, the upstream is not accountable
+	}
+	u.queries.Add(1)
+	sample := time.Since(start)
+	if failed(err) {
+		u.failures.Add(1)
+		sample = max(sample, failurePenalty)
+	}
+	u.measure(sample)
+	if ctx.Err() == nil || errors.Is(context.Cause(ctx), errShareExpired) {
+		// a query reaching its deadline does not tell the health of the upstream, a single name may be slow to resolve,
+		// but an upstream using its whole share of the deadline is not answering
+		u.report(!unhealthy(err), c.threshold.Load())
+	}
+	return records, err
+}
+
+// measure update the smoothed round trip time with a new sample, see rfc6298 section 2
+func (u *upstream) measure(sample time.Duration) {
+	for {
+		old := u.rtt.Load()
+		rtt := int64(sample)
+		if old != 0 {
+			rtt = old + (int64(sample)-old)/8
+		}
+		if u.rtt.CompareAndSwap(old, rtt) {
+			return
+		}
+	}
+}
+
+// unhealthy returns true when the upstream could not be reached, an upstream answering with an error code is healthy
+func unhealthy(err error) bool {
+	var transportErr *client.TransportError
+	return errors.As(err, &transportErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// failed returns true when the error is a failure of the upstream, the negative answers are not failures
+func failed(err error) bool {
+	return err != nil && !errors.Is(err, client.ErrNameError) && !errors.Is(err, client.ErrNoData) && !errors.Is(err, client.ErrNotFound)
+}
@@ -0,0 +1,177 @@
+package multiple
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// mockClient answers with its name after the delay, or fails
+type mockClient struct {
+	name  string
+	delay time.Duration
+	err   error
+	calls atomic.Int32
+}
+
+// Resolve implements client.Client
+func (m *mockClient) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, error) {
+	m.calls.Add(1)
+	select {
+	case <-time.After(m.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return []dto.Record{{Name: question.Name, Type: dto.TXT, Class: dto.IN, TTL: 60, Data: dto.TXTData{m.name}}}, nil
+}
+
+func upstreams(clients ...*mockClient) []Upstream {
+	res := make([]Upstream, 0, len(clients))
+	for _, c := range clients {
+		res = append(res, Upstream{Name: c.name, Client: c})
+	}
+	return res
+}
+
+func resolve(t *testing.T, c *MultipleClient) string {
+	t.Helper()
+	records, err := c.Resolve(context.Background(), dto.Question{Name: "example.com", Type: dto.TXT, Class: dto.IN})
+	if err != nil {
+		t.Fatalf("MultipleClient.Resolve() error = %v", err)
+	}
+	return records[0].Data.(dto.TXTData)[0]
+}
+
+func TestMultipleClient_Failover(t *testing.T) {
+	failing := &mockClient{name: "failing", err: errors.New("refused")}
+	working := &mockClient{name: "working"}
+	c := NewMultipleClient(Failover, 0, upstreams(failing, working)...)
+	if got := resolve(t, c); got != "working" {
+		t.Fatalf("expecting the answer of the working upstream, got %s", got)
+	}
+
+	stats := c.Stats()
+	if stats[0].Queries != 1 || stats[0].Failures != 1 || stats[1].Queries != 1 || stats[1].Failures != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats[0].RTT < failurePenalty {
+		t.Errorf("the failing upstream should have a penalty, got rtt %v", stats[0].RTT)
+	}
+}
+
+func TestMultipleClient_SilentUpstream(t *testing.T) {
+	silent := &mockClient{name: "silent", delay: time.Hour}
+	working := &mockClient{name: "working"}
+	c := NewMultipleClient(Failover, 0, upstreams(silent, working)...)
+	c.threshold.Store(1)
+	// the silent upstream uses its share of the deadline, the working one still has time to answer
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	records, err := c.Resolve(ctx, dto.Question{Name: "example.com", Type: dto.TXT, Class: dto.IN})
+	if err != nil || records[0].Data.(dto.TXTData)[0] != "working" {
+		t.Fatalf("expecting the answer of the working upstream, got %v, %v", records, err)
+	}
+	if stats := c.Stats(); !stats[0].Down || stats[1].Down {
+		t.Errorf("the silent upstream should be down, got %+v", stats)
+	}
+
+	// the query reaching its own deadline does not tell the health of the upstream
+	c = NewMultipleClient(Failover, 0, upstreams(silent)...)
+	c.threshold.Store(1)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Resolve(ctx, dto.Question{Name: "example.com", Type: dto.TXT, Class: dto.IN}); err == nil {
+		t.Fatal("expecting an error from the silent upstream")
+	}
+	if stats := c.Stats(); stats[0].Down {
+		t.Errorf("the deadline of the query should not be counted against the upstream, got %+v", stats)
+	}
+}
+
+func TestMultipleClient_NegativeAnswer(t *testing.T) {
+	nxdomain := &mockClient{name: "nxdomain", err: client.ErrNameError}
+	other := &mockClient{name: "other"}
+	c := NewMultipleClient(Failover, 0, upstreams(nxdomain, other)...)
+	_, err := c.Resolve(context.Background(), dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN})
+	if !errors.Is(err, client.ErrNameError) || other.calls.Load() != 0 {
+		t.Errorf("a negative answer should be returned without asking the other upstreams, got %v", err)
+	}
+}
+
+func TestMultipleClient_AllFailing(t *testing.T) {
+	c := NewMultipleClient(Failover, 0, upstreams(&mockClient{name: "a", err: errors.New("a")}, &mockClient{name: "b", err: errors.New("b")})...)
+	if _, err := c.Resolve(context.Background(), dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}); err == nil {
+		t.Errorf("expecting an error when every upstream fails")
+	}
+}
+
+func TestMultipleClient_RoundRobin(t *testing.T) {
+	c := NewMultipleClient(RoundRobin, 0, upstreams(&mockClient{name: "a"}, &mockClient{name: "b"}, &mockClient{name: "c"})...)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, resolve(t, c))
+	}
+	if want := []string{"a", "b", "c", "a"}; !equal(got, want) {
+		t.Errorf("expecting %v, got %v", want, got)
+	}
+}
+
+func TestMultipleClient_LowestLatency(t *testing.T) {
+	slow := &mockClient{name: "slow", delay: 30 * time.Millisecond}
+	fast := &mockClient{name: "fast", delay: time.Millisecond}
+	c := NewMultipleClient(LowestLatency, 0, upstreams(slow, fast)...)
+	// the first queries measure every upstream
+	resolve(t, c)
+	resolve(t, c)
+	for i := 0; i < 3; i++ {
+		if got := resolve(t, c); got != "fast" {
+			t.Fatalf("expecting the fastest upstream, got %s", got)
+		}
+	}
+	if slow.calls.Load() != 1 {
+		t.Errorf("the slow upstream should only be asked once, got %d", slow.calls.Load())
+	}
+}
+
+func TestMultipleClient_Race(t *testing.T) {
+	slow := &mockClient{name: "slow", delay: 500 * time.Millisecond}
+	fast := &mockClient{name: "fast", delay: time.Millisecond}
+	spare := &mockClient{name: "spare"}
+	c := NewMultipleClient(Race, 2, upstreams(slow, fast, spare)...)
+	start := time.Now()
+	if got := resolve(t, c); got != "fast" {
+		t.Fatalf("expecting the fastest upstream, got %s", got)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the race waited for the slow upstream, %v", elapsed)
+	}
+	if spare.calls.Load() != 0 {
+		t.Errorf("the upstreams out of the race should not be asked")
+	}
+
+	// the raced upstreams fail, the others are asked
+	c = NewMultipleClient(Race, 2, upstreams(&mockClient{name: "a", err: errors.New("a")}, &mockClient{name: "b", err: errors.New("b")}, spare)...)
+	if got := resolve(t, c); got != "spare" {
+		t.Fatalf("expecting the spare upstream, got %s", got)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package recursive
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDelegations maximum number of zones kept in the delegation cache
+const maxDelegations = 10000
+
+// maxDelegationTTL the delegations are asked again at least once a day
+const maxDelegationTTL = 24 * time.Hour
+
+// nameserver is a server of a zone, its addresses are the glue of the referral or are resolved when first used
+type nameserver struct {
+	name      string
+	addresses []string
+}
+
+// delegation is the set of servers of a zone
+type delegation struct {
+	zone    string
+	expires time.Time
+
+	lock    sync.Mutex // protects the servers addresses
+	servers []nameserver
+}
+
+func newDelegation(zone string, servers []nameserver, ttl time.Duration) *delegation {
+	return &delegation{
+		zone:    zone,
+		expires: time.Now().Add(min(ttl, maxDelegationTTL)),
+		servers: servers,
+	}
+}
+
+// server returns the i-th server of the zone
+func (d *delegation) server(i int) nameserver {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.servers[i]
+}
+
+// resolved remembers the addresses of the i-th server of the zone
+func (d *delegation) resolved(i int, addresses []string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.servers[i].addresses = addresses
+}
+
+// delegationCache remembers the servers of the zones already visited, the resolution starts at the closest known zone
+type delegationCache struct {
+	roots *delegation
+	lock  sync.RWMutex
+	zones map[string]*delegation
+}
+
+func newDelegationCache(roots []nameserver) *delegationCache {
+	return &delegationCache{
+		roots: &delegation{servers: roots},
+		zones: make(map[string]*delegation),
+	}
+}
+
+// closest returns the delegation of the longest zone containing the name, the root servers when none is known
+func (c *delegationCache) closest(name string) *delegation {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := time.Now()
+	for name != "" {
+		if d, ok := c.zones[name]; ok && now.Before(d.expires) {
+			return d
+		}
+		_, name, _ = strings.Cut(name, ".")
+	}
+	return c.roots
+}
+
+func (c *delegationCache) add(d *delegation) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.zones) >= maxDelegations {
+		c.evict()
+	}
+	c.zones[d.zone] = d
+}
+
+// evict removes the expired delegations, a random one is removed when none is expired
+func (c *delegationCache) evict() {
+	now := time.Now()
+	for zone, d := range c.zones {
+		if !now.Before(d.expires) {
+			delete(c.zones, zone)
+		}
+	}
+	for zone := range c.zones {
+		if len(c.zones) < maxDelegations {
+			return
+		}
+		delete(c.zones, zone)
+	}
+}
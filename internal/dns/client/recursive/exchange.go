@@ -0,0 +1,74 @@
+package recursive
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/udp"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// exchange sends the question to the server without asking for recursion, truncated answers are asked again over tcp
+func (c *RecursiveClient) exchange(ctx context.Context, ip string, question dto.Question) (*dto.Message, error) {
+	address := net.JoinHostPort(ip, c.port)
+	query := client.NewQuery(client.RandomID(), question)
+	query.Header.SetRD(false)
+	query.SetEDNS(dto.EDNS{UDPSize: dto.EDNSUDPLength})
+	response, err := udp.Exchange(ctx, address, &query)
+	if err == nil && response.Header.TC() {
+		// the answer does not fit in a udp message, see rfc7766 section 5
+		response, err = exchangeTCP(ctx, address, &query)
+	}
+	return response, err
+}
+
+// exchangeTCP sends the query on a new connection closed once answered
+func exchangeTCP(ctx context.Context, address string, query *dto.Message) (*dto.Message, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	setDeadline(ctx, conn)
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	payload := dto.AppendMessage(make([]byte, 2, dto.ClassicUDPLength), query)
+	binary.BigEndian.PutUint16(payload, uint16(len(payload)-2))
+	if _, err := conn.Write(payload); err != nil {
+		return nil, err
+	}
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, length)
+	if _, err := io.ReadFull(conn, buffer); err != nil {
+		return nil, err
+	}
+	response, err := dto.ParseMessage(buffer)
+	if err != nil {
+		return nil, err
+	}
+	if !answers(response, query) {
+		return nil, errors.New("the response does not match the query")
+	}
+	return response, nil
+}
+
+func setDeadline(ctx context.Context, conn net.Conn) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+}
+
+// answers returns true when the message is the response to the query
+func answers(response *dto.Message, query *dto.Message) bool {
+	return response.Header.QR() && response.ID == query.ID && client.SameQuestion(response, query.Question[0])
+}
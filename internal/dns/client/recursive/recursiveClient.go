@@ -0,0 +1,310 @@
+package recursive
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ client.Client = &RecursiveClient{}
+
+const (
+	defaultPort    = "53"
+	defaultTimeout = 2 * time.Second
+	// maxQueries maximum number of queries sent to resolve a question, the resolutions of the servers addresses included
+	maxQueries = 100
+	// maxSteps maximum number of queries sent to resolve a name, the referrals and the minimised queries included
+	maxSteps = 32
+	// maxChainLength maximum number of aliases followed
+	maxChainLength = 8
+	// maxDepth maximum nesting of the resolutions of the servers addresses
+	maxDepth = 4
+)
+
+// Config of a RecursiveClient
+type Config struct {
+	// Roots addresses of the root servers, the root hints of the IANA are used when empty
+	Roots []string
+	// Port of the authoritative servers, 53 when empty
+	Port string
+	// Timeout of a query sent to a single server, 2 seconds when zero
+	Timeout time.Duration
+}
+
+// RecursiveClient resolves the questions iteratively from the root servers, following the referrals and the aliases, see rfc1034 section 5.3.3.
+// The servers of the visited zones are cached, the names are minimised so that each server only learns the next label, see rfc9156
+type RecursiveClient struct {
+	port        string
+	timeout     time.Duration
+	delegations *delegationCache
+}
+
+// resolution is the state of the resolution of a question, shared with the resolutions of the servers addresses
+type resolution struct {
+	queries int
+}
+
+// NewRecursiveClient instantiate a RecursiveClient
+func NewRecursiveClient(conf Config) *RecursiveClient {
+	roots := slices.Clone(rootHints)
+	if len(conf.Roots) > 0 {
+		roots = make([]nameserver, 0, len(conf.Roots))
+		for _, ip := range conf.Roots {
+			roots = append(roots, nameserver{name: ip, addresses: []string{ip}})
+		}
+	}
+	res := &RecursiveClient{
+		port:        conf.Port,
+		timeout:     conf.Timeout,
+		delegations: newDelegationCache(roots),
+	}
+	if res.port == "" {
+		res.port = defaultPort
+	}
+	if res.timeout == 0 {
+		res.timeout = defaultTimeout
+	}
+	return res
+}
+
+// Resolve implements client.Client
+func (c *RecursiveClient) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, error) {
+	return c.resolve(ctx, &resolution{}, question, 0)
+}
+
+// resolve returns the records of the question, the aliases are followed and returned before the records of their target
+func (c *RecursiveClient) resolve(ctx context.Context, r *resolution, question dto.Question, depth int) ([]dto.Record, error) {
+	question.Name = normalize(question.Name)
+	var answer []dto.Record
+	for i := 0; i <= maxChainLength; i++ {
+		records, target, err := c.lookup(ctx, r, question, depth)
+		if err != nil {
+			return nil, err
+		}
+		answer = append(answer, records...)
+		if target == "" {
+			return answer, nil
+		}
+		question.Name = target
+	}
+	return nil, errors.New("too many aliases to resolve " + question.Name)
+}
+
+// lookup resolves the question from the closest known zone, the target is set when the name is an alias
+func (c *RecursiveClient) lookup(ctx context.Context, r *resolution, question dto.Question, depth int) ([]dto.Record, string, error) {
+	d := c.delegations.closest(question.Name)
+	known := d.zone // deepest ancestor of the name known to be served by the servers of d
+	for step := 0; step < maxSteps; step++ {
+		name := nextName(question.Name, known)
+		minimised := dto.Question{Name: name, Type: dto.A, Class: question.Class}
+		if name == question.Name {
+			minimised.Type = question.Type
+		}
+		response, err := c.ask(ctx, r, d, minimised, depth)
+		if err != nil {
+			return nil, "", err
+		}
+		if response.RCode() == dto.NXDOMAIN {
+			// nothing exists below a name which does not exist, see rfc8020
+			return nil, "", negative(client.ErrNameError, response, d.zone, name)
+		}
+		if cut, ok := referral(response, d.zone, name); ok {
+			d = c.delegate(response, d.zone, cut)
+			known = cut
+			continue
+		}
+		if name != question.Name {
+			// the name is not delegated, its next label is asked to the same servers
+			known = name
+			continue
+		}
+		return answer(response, question, d.zone)
+	}
+	return nil, "", errors.New("too many referrals to resolve " + question.Name)
+}
+
+// ask sends the question to the servers of the zone until one of them answers
+func (c *RecursiveClient) ask(ctx context.Context, r *resolution, d *delegation, question dto.Question, depth int) (*dto.Message, error) {
+	var errs []error
+	for i := range d.servers {
+		addresses, err := c.addresses(ctx, r, d, i, depth)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, ip := range addresses {
+			if r.queries >= maxQueries {
+				return nil, errors.New("too many queries to resolve " + question.Name)
+			}
+			r.queries++
+			response, err := c.query(ctx, ip, question)
+			if err == nil {
+				return response, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no server known for the zone " + d.zone)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// query asks a single server, the error responses other than NXDOMAIN are failures of the server
+func (c *RecursiveClient) query(ctx context.Context, ip string, question dto.Question) (*dto.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	response, err := c.exchange(ctx, ip, question)
+	if err != nil {
+		return nil, client.TransportFailure(err)
+	}
+	switch rcode := response.RCode(); rcode {
+	case dto.NOERROR, dto.NXDOMAIN:
+		return response, nil
+	default:
+		return nil, errors.New("server " + ip + " answered with rcode " + strconv.Itoa(int(rcode)))
+	}
+}
+
+// addresses returns the addresses of the i-th server of the zone, they are resolved when the referral had no glue
+func (c *RecursiveClient) addresses(ctx context.Context, r *resolution, d *delegation, i int, depth int) ([]string, error) {
+	server := d.server(i)
+	if len(server.addresses) > 0 {
+		return server.addresses, nil
+	}
+	if depth >= maxDepth {
+		return nil, errors.New("too deep resolution of the server " + server.name)
+	}
+	records, err := c.resolve(ctx, r, dto.Question{Name: server.name, Type: dto.A, Class: dto.IN}, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(records))
+	for _, record := range records {
+		if ip, ok := record.Data.(dto.IPData); ok && record.Type == dto.A {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	d.resolved(i, addresses)
+	return addresses, nil
+}
+
+// delegate caches the servers of the delegated zone found in the referral
+func (c *RecursiveClient) delegate(response *dto.Message, zone string, cut string) *delegation {
+	var servers []nameserver
+	ttl := maxDelegationTTL
+	for _, record := range response.Authority {
+		ns, ok := record.Data.(dto.NameData)
+		if record.Type != dto.NS || !ok || normalize(record.Name) != cut {
+			continue
+		}
+		server := nameserver{name: normalize(string(ns))}
+		server.addresses = glue(response.Additional, server.name, zone)
+		servers = append(servers, server)
+		ttl = min(ttl, time.Duration(record.TTL)*time.Second)
+	}
+	d := newDelegation(cut, servers, ttl)
+	c.delegations.add(d)
+	return d
+}
+
+// referral returns the zone delegated by the response, it must be below the zone of the servers and contain the name
+func referral(response *dto.Message, zone string, name string) (string, bool) {
+	if len(response.Response) > 0 {
+		return "", false
+	}
+	for _, record := range response.Authority {
+		cut := normalize(record.Name)
+		if record.Type == dto.NS && cut != zone && isSubdomain(cut, zone) && isSubdomain(name, cut) {
+			return cut, true
+		}
+	}
+	return "", false
+}
+
+// glue returns the addresses of the server found in the additional section, ipv4 first.
+// Only the addresses in the zone of the servers sending them are trusted, see rfc2181 section 5.4.1
+func glue(additional []dto.Record, server string, zone string) []string {
+	if !isSubdomain(server, zone) {
+		return nil
+	}
+	var res []string
+	for _, t := range []dto.Type{dto.A, dto.AAAA} {
+		for _, record := range additional {
+			if ip, ok := record.Data.(dto.IPData); ok && record.Type == t && normalize(record.Name) == server {
+				res = append(res, ip.String())
+			}
+		}
+	}
+	return res
+}
+
+// answer returns the records of the question in the response, or the alias of the name and its target
+func answer(response *dto.Message, question dto.Question, zone string) ([]dto.Record, string, error) {
+	var records []dto.Record
+	var alias *dto.Record
+	for i, record := range response.Response {
+		if normalize(record.Name) != question.Name {
+			continue
+		}
+		if record.Type == question.Type {
+			records = append(records, record)
+		} else if record.Type == dto.CNAME && alias == nil {
+			alias = &response.Response[i]
+		}
+	}
+	if len(records) > 0 {
+		return records, "", nil
+	}
+	if alias == nil {
+		return nil, "", negative(client.ErrNoData, response, zone, question.Name)
+	}
+	target, ok := alias.Data.(dto.NameData)
+	if !ok {
+		return nil, "", errors.New("malformed alias of " + question.Name)
+	}
+	return []dto.Record{*alias}, normalize(string(target)), nil
+}
+
+// negative returns the negative answer of the kind, only the SOA of a zone served by the servers and containing the name is kept
+func negative(kind error, response *dto.Message, zone string, name string) error {
+	authority := make([]dto.Record, 0, 1)
+	for _, record := range response.Authority {
+		owner := normalize(record.Name)
+		if isSubdomain(owner, zone) && isSubdomain(name, owner) {
+			authority = append(authority, record)
+		}
+	}
+	return client.Negative(kind, authority)
+}
+
+// nextName returns the ancestor of the name with one more label than the given ancestor, see rfc9156 section 3
+func nextName(name string, ancestor string) string {
+	if name == ancestor {
+		return name
+	}
+	prefix := name
+	if ancestor != "" {
+		prefix = name[:len(name)-len(ancestor)-1]
+	}
+	return name[strings.LastIndexByte(prefix, '.')+1:]
+}
+
+// isSubdomain returns true when the name is the zone or is below it
+func isSubdomain(name string, zone string) bool {
+	return zone == "" || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// normalize returns the name in lower case without its trailing dot, names are case insensitive see rfc4343
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,239 @@
+package recursive
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// authority is a fake authoritative server of a zone, the NS records of its zone cuts are answered with referrals
+type authority struct {
+	zone    string
+	records []dto.Record
+	glue    []dto.Record
+	conn    net.PacketConn
+	lock    sync.Mutex
+	asked   []string
+}
+
+func (a *authority) serve() {
+	buffer := make([]byte, dto.UDPMaxLength)
+	for {
+		n, addr, err := a.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		query, err := dto.ParseMessage(buffer[:n])
+		if err != nil || query.Header.RD() {
+			continue
+		}
+		a.lock.Lock()
+		a.asked = append(a.asked, query.Question[0].Name)
+		a.lock.Unlock()
+		response := a.answer(query)
+		_, _ = a.conn.WriteTo(dto.SerializeMessage(response), addr)
+	}
+}
+
+func (a *authority) answer(query *dto.Message) dto.Message {
+	question := query.Question[0]
+	response := dto.Message{ID: query.ID, QuestionCount: 1, Question: query.Question}
+	response.Header.SetQR(true)
+	for _, record := range a.records {
+		if record.Type == dto.NS && record.Name != a.zone && isSubdomain(question.Name, record.Name) {
+			return a.referral(response, record.Name)
+		}
+	}
+	response.Header.SetAA(true)
+	exists := false
+	for _, record := range a.records {
+		if record.Name == question.Name && (record.Type == question.Type || record.Type == dto.CNAME) {
+			response.Response = append(response.Response, record)
+		}
+		exists = exists || isSubdomain(record.Name, question.Name)
+	}
+	if !exists {
+		response.Header.SetRCode(dto.NXDOMAIN)
+	}
+	if len(response.Response) == 0 {
+		// the negative answers carry the SOA of the zone, see rfc2308 section 3
+		response.Authority = []dto.Record{soa(a.zone)}
+		response.AuthorityCount = 1
+	}
+	response.ResponseCount = uint16(len(response.Response))
+	return response
+}
+
+func (a *authority) referral(response dto.Message, cut string) dto.Message {
+	for _, record := range a.records {
+		if record.Type == dto.NS && record.Name == cut {
+			response.Authority = append(response.Authority, record)
+		}
+	}
+	response.Additional = a.glue
+	response.AuthorityCount = uint16(len(response.Authority))
+	response.AdditionalCount = uint16(len(response.Additional))
+	return response
+}
+
+func (a *authority) questions() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return append([]string(nil), a.asked...)
+}
+
+func ns(zone string, server string) dto.Record {
+	return dto.Record{Name: zone, Type: dto.NS, Class: dto.IN, TTL: 3600, Data: dto.NameData(server)}
+}
+
+func a(name string, ip string) dto.Record {
+	return dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData(net.ParseIP(ip).To4())}
+}
+
+func soa(zone string) dto.Record {
+	return dto.Record{Name: zone, Type: dto.SOA, Class: dto.IN, TTL: 300, Data: dto.SOAData{MName: "ns." + zone, RName: "noc." + zone, Serial: 1, Minimum: 300}}
+}
+
+func cname(name string, target string) dto.Record {
+	return dto.Record{Name: name, Type: dto.CNAME, Class: dto.IN, TTL: 60, Data: dto.NameData(target)}
+}
+
+// hierarchy starts the authorities of a small tree of zones on loopback addresses sharing the same port:
+// the root on 127.0.0.1, test on 127.0.0.2, corp.test on 127.0.0.3, example on 127.0.0.4 and sub.corp.test on 127.0.0.5
+func hierarchy(t *testing.T) (map[string]*authority, *RecursiveClient) {
+	authorities := map[string]*authority{
+		"127.0.0.1": {zone: "",
+			records: []dto.Record{ns("test", "ns1.test"), ns("example", "ns.other.test")},
+			glue:    []dto.Record{a("ns1.test", "127.0.0.2")}},
+		"127.0.0.2": {zone: "test",
+			records: []dto.Record{ns("corp.test", "ns.corp.test"), a("ns1.test", "127.0.0.2"), a("ns.other.test", "127.0.0.4")},
+			glue:    []dto.Record{a("ns.corp.test", "127.0.0.3")}},
+		"127.0.0.3": {zone: "corp.test",
+			records: []dto.Record{a("www.corp.test", "10.0.0.1"), cname("alias.corp.test", "www.example"), ns("sub.corp.test", "ns.sub.example")},
+			// out of the zone, the glue must be ignored
+			glue: []dto.Record{a("ns.sub.example", "127.0.0.9")}},
+		"127.0.0.4": {zone: "example",
+			records: []dto.Record{a("www.example", "10.0.0.2"), a("ns.sub.example", "127.0.0.5")}},
+		"127.0.0.5": {zone: "sub.corp.test",
+			records: []dto.Record{a("www.sub.corp.test", "10.0.0.3")}},
+	}
+	port := ""
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3", "127.0.0.4", "127.0.0.5"} {
+		conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, port))
+		if err != nil {
+			t.Skip("loopback addresses unavailable:", err)
+		}
+		_, port, _ = net.SplitHostPort(conn.LocalAddr().String())
+		authorities[ip].conn = conn
+		go authorities[ip].serve()
+		t.Cleanup(func() { _ = conn.Close() })
+	}
+	return authorities, NewRecursiveClient(Config{Roots: []string{"127.0.0.1"}, Port: port, Timeout: 200 * time.Millisecond})
+}
+
+func TestRecursiveClient_Resolve(t *testing.T) {
+	_, c := hierarchy(t)
+
+	tests := []struct {
+		name     string
+		question dto.Question
+		want     []dto.Record
+		wantErr  error
+		wantSOA  string
+	}{
+		{name: "delegated", question: dto.Question{Name: "www.corp.test", Type: dto.A, Class: dto.IN}, want: []dto.Record{a("www.corp.test", "10.0.0.1")}},
+		{name: "case insensitive", question: dto.Question{Name: "WWW.Corp.Test.", Type: dto.A, Class: dto.IN}, want: []dto.Record{a("www.corp.test", "10.0.0.1")}},
+		{name: "alias to a zone served without glue", question: dto.Question{Name: "alias.corp.test", Type: dto.A, Class: dto.IN},
+			want: []dto.Record{cname("alias.corp.test", "www.example"), a("www.example", "10.0.0.2")}},
+		{name: "out of zone glue ignored", question: dto.Question{Name: "www.sub.corp.test", Type: dto.A, Class: dto.IN}, want: []dto.Record{a("www.sub.corp.test", "10.0.0.3")}},
+		{name: "name error", question: dto.Question{Name: "missing.corp.test", Type: dto.A, Class: dto.IN}, wantErr: client.ErrNameError, wantSOA: "corp.test"},
+		{name: "name error above the zone cut", question: dto.Question{Name: "www.missing.test", Type: dto.A, Class: dto.IN}, wantErr: client.ErrNameError, wantSOA: "test"},
+		{name: "no data", question: dto.Question{Name: "www.corp.test", Type: dto.MX, Class: dto.IN}, wantErr: client.ErrNoData, wantSOA: "corp.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Resolve(context.Background(), tt.question)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RecursiveClient.Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RecursiveClient.Resolve() = %v, want %v", got, tt.want)
+			}
+			var negative *client.NegativeAnswer
+			if tt.wantSOA != "" && (!errors.As(err, &negative) || !reflect.DeepEqual(negative.Authority, []dto.Record{soa(tt.wantSOA)})) {
+				t.Errorf("RecursiveClient.Resolve() error = %#v, expecting the SOA of %s", err, tt.wantSOA)
+			}
+		})
+	}
+}
+
+func TestRecursiveClient_Minimisation(t *testing.T) {
+	authorities, c := hierarchy(t)
+	if _, err := c.Resolve(context.Background(), dto.Question{Name: "www.sub.corp.test", Type: dto.A, Class: dto.IN}); err != nil {
+		t.Fatal(err)
+	}
+	for ip, authority := range authorities {
+		for _, name := range authority.questions() {
+			if name == "www.sub.corp.test" && ip != "127.0.0.5" {
+				t.Errorf("the full name was sent to the server %s of the zone %q", ip, authority.zone)
+			}
+		}
+	}
+	for _, name := range authorities["127.0.0.1"].questions() {
+		if strings.Contains(name, ".") {
+			t.Errorf("the root server was asked %s, expecting a single label", name)
+		}
+	}
+}
+
+func TestRecursiveClient_DelegationCache(t *testing.T) {
+	authorities, c := hierarchy(t)
+	question := dto.Question{Name: "www.corp.test", Type: dto.A, Class: dto.IN}
+	if _, err := c.Resolve(context.Background(), question); err != nil {
+		t.Fatal(err)
+	}
+	root, tld := len(authorities["127.0.0.1"].questions()), len(authorities["127.0.0.2"].questions())
+
+	if _, err := c.Resolve(context.Background(), dto.Question{Name: "alias.corp.test", Type: dto.CNAME, Class: dto.IN}); err != nil {
+		t.Fatal(err)
+	}
+	if len(authorities["127.0.0.1"].questions()) != root || len(authorities["127.0.0.2"].questions()) != tld {
+		t.Errorf("the parent zones were asked again instead of the cached delegation")
+	}
+}
+
+func TestRecursiveClient_Cancelled(t *testing.T) {
+	_, c := hierarchy(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Resolve(ctx, dto.Question{Name: "www.corp.test", Type: dto.A, Class: dto.IN}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RecursiveClient.Resolve() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestNextName(t *testing.T) {
+	tests := []struct {
+		name     string
+		ancestor string
+		want     string
+	}{
+		{name: "www.corp.test", ancestor: "", want: "test"},
+		{name: "www.corp.test", ancestor: "test", want: "corp.test"},
+		{name: "www.corp.test", ancestor: "corp.test", want: "www.corp.test"},
+		{name: "www.corp.test", ancestor: "www.corp.test", want: "www.corp.test"},
+		{name: "", ancestor: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := nextName(tt.name, tt.ancestor); got != tt.want {
+			t.Errorf("nextName(%q, %q) = %q, want %q", tt.name, tt.ancestor, got, tt.want)
+		}
+	}
+}
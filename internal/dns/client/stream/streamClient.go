@@ -0,0 +1,232 @@
+package stream
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ client.Client = &Client{}
+
+// maxPending maximum number of queries waiting for their answer on a connection
+const maxPending = 1024
+
+// errConnectionClosed is returned to the queries pending on a connection which is closed
+var errConnectionClosed = errors.New("connection closed")
+
+// DialFunc open a new connection to the upstream server
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+// Client resolves questions over a persistent stream connection where each message is prefixed by its length, see rfc1035 section 4.2.2.
+// The queries are pipelined: they are sent without waiting for the answers of the previous ones, see rfc7766 section 6.2.1.1
+type Client struct {
+	dial    DialFunc
+	lock    sync.Mutex
+	current *pipeline
+}
+
+// NewClient instantiate a Client opening its connections with the dial function
+func NewClient(dial DialFunc) *Client {
+	return &Client{dial: dial}
+}
+
+// Resolve implements client.Client
+func (c *Client) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, error) {
+	response, err := c.exchange(ctx, question)
+	if errors.Is(err, errConnectionClosed) && ctx.Err() == nil {
+		// the server closes the connections it considers idle, retry once on a new connection
+		response, err = c.exchange(ctx, question)
+	}
+	if err != nil {
+		return nil, client.TransportFailure(err)
+	}
+	return client.Answer(response)
+}
+
+// Close the current connection, the next query opens a new one
+func (c *Client) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.current != nil {
+		c.current.fail(net.ErrClosed)
+		c.current = nil
+	}
+}
+
+func (c *Client) exchange(ctx context.Context, question dto.Question) (*dto.Message, error) {
+	p, err := c.pipeline(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.exchange(ctx, question)
+}
+
+// pipeline returns the current connection, a new one is opened if there is none or if it is closed
+func (c *Client) pipeline(ctx context.Context) (*pipeline, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.current != nil && c.current.alive() {
+		return c.current, nil
+	}
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.current = newPipeline(conn)
+	return c.current, nil
+}
+
+// pipeline is a connection on which several queries are pending, the answers are matched with the queries by id
+type pipeline struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	lastRead  atomic.Int64
+
+	lock    sync.Mutex // protects the fields below
+	pending map[uint16]chan *dto.Message
+	id      uint16
+	err     error
+}
+
+func newPipeline(conn net.Conn) *pipeline {
+	p := &pipeline{
+		conn:    conn,
+		pending: make(map[uint16]chan *dto.Message),
+	}
+	go p.readLoop()
+	return p
+}
+
+func (p *pipeline) exchange(ctx context.Context, question dto.Question) (*dto.Message, error) {
+	id, answer, err := p.register()
+	if err != nil {
+		return nil, err
+	}
+	defer p.unregister(id)
+
+	query := client.NewQuery(id, question)
+	payload := dto.AppendMessage(make([]byte, 2, dto.ClassicUDPLength), &query)
+	binary.BigEndian.PutUint16(payload, uint16(len(payload)-2))
+	sent := time.Now()
+	if err := p.write(ctx, payload); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response, ok := <-answer:
+		if !ok {
+			return nil, p.error()
+		}
+		if !client.SameQuestion(response, query.Question[0]) {
+			return nil, errors.New("the response does not match the question " + question.Name)
+		}
+		return response, nil
+	case <-ctx.Done():
+		if p.lastRead.Load() < sent.UnixNano() {
+			// nothing was received since the query was sent, the connection is likely broken
+			p.fail(errors.New("no response received"))
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (p *pipeline) write(ctx context.Context, payload []byte) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	deadline, _ := ctx.Deadline()
+	_ = p.conn.SetWriteDeadline(deadline)
+	if _, err := p.conn.Write(payload); err != nil {
+		// a partial write breaks the framing of the connection, it can not be used anymore
+		p.fail(err)
+		return p.error()
+	}
+	return nil
+}
+
+func (p *pipeline) readLoop() {
+	buffer := make([]byte, dto.BufferMaxLength)
+	for {
+		if _, err := io.ReadFull(p.conn, buffer[:2]); err != nil {
+			p.fail(err)
+			return
+		}
+		length := binary.BigEndian.Uint16(buffer[:2])
+		if _, err := io.ReadFull(p.conn, buffer[:length]); err != nil {
+			p.fail(err)
+			return
+		}
+		p.lastRead.Store(time.Now().UnixNano())
+		response, err := dto.ParseMessage(buffer[:length])
+		if err != nil {
+			log.Println("malformed response from", p.conn.RemoteAddr(), err)
+			continue
+		}
+		p.lock.Lock()
+		answer, ok := p.pending[response.ID]
+		delete(p.pending, response.ID)
+		p.lock.Unlock()
+		if ok {
+			answer <- response
+		}
+	}
+}
+
+// register returns an id unused by the pending queries and the channel on which the answer is sent
+func (p *pipeline) register() (uint16, chan *dto.Message, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return 0, nil, p.err
+	}
+	if len(p.pending) >= maxPending {
+		return 0, nil, errors.New("too many pending queries")
+	}
+	p.id++
+	for _, ok := p.pending[p.id]; ok; _, ok = p.pending[p.id] {
+		p.id++
+	}
+	answer := make(chan *dto.Message, 1)
+	p.pending[p.id] = answer
+	return p.id, answer, nil
+}
+
+func (p *pipeline) unregister(id uint16) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.pending, id)
+}
+
+// fail closes the connection, the pending queries are answered with the error
+func (p *pipeline) fail(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return
+	}
+	p.err = fmt.Errorf("%w: %v", errConnectionClosed, err)
+	for id, answer := range p.pending {
+		close(answer)
+		delete(p.pending, id)
+	}
+	_ = p.conn.Close()
+}
+
+func (p *pipeline) alive() bool {
+	return p.error() == nil
+}
+
+func (p *pipeline) error() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.err
+}
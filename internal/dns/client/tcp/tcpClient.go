@@ -0,0 +1,31 @@
+package tcp
+
+import (
+	"context"
+	"net"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/stream"
+)
+
+var _ client.Client = &TCPClient{}
+
+const defaultPort = "53"
+
+// TCPClient resolves the questions over tcp, the connection is kept open and reused by the following queries
+type TCPClient struct {
+	*stream.Client
+}
+
+// NewTCPClient instantiate a TCPClient for the given address, the port 53 is used when missing
+func NewTCPClient(address string) *TCPClient {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+	dialer := &net.Dialer{}
+	return &TCPClient{
+		Client: stream.NewClient(func(ctx context.Context) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", address)
+		}),
+	}
+}
@@ -0,0 +1,76 @@
+package tcp
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// serveTCP answers every question with two addresses on the listener, the accepted connections are counted
+func serveTCP(listener net.Listener, connections *atomic.Int32) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		connections.Add(1)
+		go func() {
+			defer conn.Close()
+			for {
+				var length uint16
+				if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+					return
+				}
+				payload := make([]byte, length)
+				if _, err := io.ReadFull(conn, payload); err != nil {
+					return
+				}
+				query, err := dto.ParseMessage(payload)
+				if err != nil {
+					return
+				}
+				name := query.Question[0].Name
+				response := dto.Message{ID: query.ID, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: query.Question, ResponseCount: 2,
+					Response: []dto.Record{
+						{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{192, 0, 2, 1}},
+						{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{192, 0, 2, 2}},
+					}}
+				out := dto.AppendMessage(make([]byte, 2, 512), &response)
+				binary.BigEndian.PutUint16(out, uint16(len(out)-2))
+				if _, err := conn.Write(out); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestTCPClient_Resolve(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	connections := atomic.Int32{}
+	go serveTCP(listener, &connections)
+
+	c := NewTCPClient(listener.Addr().String())
+	defer c.Close()
+	for _, name := range []string{"example.com", "example.org"} {
+		records, err := c.Resolve(context.Background(), dto.Question{Name: name, Type: dto.A, Class: dto.IN})
+		if err != nil {
+			t.Fatalf("TCPClient.Resolve() error = %v", err)
+		}
+		if len(records) != 2 || records[0].Name != name {
+			t.Fatalf("TCPClient.Resolve() = %v", records)
+		}
+	}
+	if n := connections.Load(); n != 1 {
+		t.Errorf("expecting the connection to be reused, got %d connections", n)
+	}
+}
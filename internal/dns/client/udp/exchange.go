@@ -0,0 +1,77 @@
+package udp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// portAttempts number of random source ports tried before letting the system choose one
+const portAttempts = 8
+
+// Exchange sends the query from a new socket and returns the first response matching it.
+// The packets whose id or question do not match are discarded until the context is done, see rfc5452 section 9.1
+func Exchange(ctx context.Context, address string, query *dto.Message) (*dto.Message, error) {
+	conn, err := dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	// a cancelled query must not wait for the deadline
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write(dto.SerializeMessage(*query)); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, dto.UDPMaxLength)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				// the socket deadline is the deadline of the context, it may expire before the context
+				return nil, context.DeadlineExceeded
+			}
+			return nil, err
+		}
+		response, err := dto.ParseMessage(buffer[:n])
+		if err == nil && response.Header.QR() && response.ID == query.ID && client.SameQuestion(response, query.Question[0]) {
+			return response, nil
+		}
+	}
+}
+
+// dial opens a socket on a random source port, the port adds entropy to the id of the query, see rfc5452 section 4.5.
+// The socket is connected so that the packets from other addresses are dropped by the system
+func dial(ctx context.Context, address string) (net.Conn, error) {
+	for i := 0; i < portAttempts; i++ {
+		dialer := net.Dialer{LocalAddr: &net.UDPAddr{Port: randomPort()}}
+		conn, err := dialer.DialContext(ctx, "udp", address)
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return conn, err
+		}
+	}
+	dialer := net.Dialer{}
+	return dialer.DialContext(ctx, "udp", address)
+}
+
+// randomPort returns an unpredictable port above the well known ports
+func randomPort() int {
+	var port [2]byte
+	_, _ = rand.Read(port[:])
+	return 1024 + int(binary.BigEndian.Uint16(port[:]))%(65536-1024)
+}
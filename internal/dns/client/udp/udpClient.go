@@ -1,152 +1,86 @@
 package udp
 
 import (
+	"context"
 	"errors"
-	"log"
-	"math"
 	"net"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/tcp"
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
 
 var _ client.Client = &UDPClient{}
 
-var _ error = &NoResponse{}
+const defaultPort = "53"
 
-type NoResponse struct{}
-
-// Error implements error.
-func (*NoResponse) Error() string {
-	return "no response found"
-}
+// defaultTimeout is used when the context of the query has no deadline
+const defaultTimeout = 10 * time.Second
 
+// UDPClient resolves the questions over udp, truncated answers are queried again over tcp.
+// Each attempt is sent from a new socket on a random port with a random id, see rfc5452
 type UDPClient struct {
-	id            uint16
-	connexionPool *sync.Pool
-	bufferPool    *sync.Pool
-	idMutex       sync.Locker
+	address string
+	retries int
+	tcp     *tcp.TCPClient
 }
 
-// NewUDPClient instantiate a UDPClient for the given address
-func NewUDPClient(address string) *UDPClient {
+// NewUDPClient instantiate a UDPClient for the given address, the port 53 is used when missing.
+// The queries unanswered are sent again up to retries times, the deadline of the query is shared between the attempts
+func NewUDPClient(address string, retries int) *UDPClient {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
 	return &UDPClient{
-		id:      0,
-		idMutex: &sync.Mutex{},
-		connexionPool: &sync.Pool{New: func() any {
-			udpConn, err := net.Dial("udp", address)
-			if err != nil {
-				panic(err)
-			}
-			return udpConn
-		}},
-		bufferPool: &sync.Pool{New: func() any {
-			return make([]byte, dto.BufferMaxLength)
-		}},
+		address: address,
+		retries: max(0, retries),
+		tcp:     tcp.NewTCPClient(address),
 	}
 }
 
-func (c *UDPClient) ResolveV4(name string) (dto.Record, error) {
-
-	question := dto.Question{
-		Name:  name,
-		Type:  dto.A,
-		Class: dto.IN,
-	}
-
-	return c.resolve(question)
+// ResolveV4 resolve the ipv4 addresses of the name
+func (c *UDPClient) ResolveV4(name string) ([]dto.Record, error) {
+	return c.Resolve(context.Background(), dto.Question{Name: name, Type: dto.A, Class: dto.IN})
 }
 
-func (c *UDPClient) ResolveV6(name string) (dto.Record, error) {
-	question := dto.Question{
-		Name:  name,
-		Type:  dto.AAAA,
-		Class: dto.IN,
-	}
-	return c.resolve(question)
+// ResolveV6 resolve the ipv6 addresses of the name
+func (c *UDPClient) ResolveV6(name string) ([]dto.Record, error) {
+	return c.Resolve(context.Background(), dto.Question{Name: name, Type: dto.AAAA, Class: dto.IN})
 }
 
-func (c *UDPClient) resolve(request dto.Question) (dto.Record, error) {
-
-	request.Name = strings.TrimRight(request.Name, ".")
-
-	udpConn := c.getConn()
-	defer c.recycleConn(udpConn)
-
-	message := dto.Message{
-		ID:            c.nextID(),
-		Header:        dto.STANDARD_QUERY,
-		QuestionCount: 1,
-		ResponseCount: 0,
-		Question:      []dto.Question{request},
-		Response:      []dto.Record{},
-	}
-
-	payload := dto.SerializeMessage(message)
-
-	_, err := udpConn.Write(payload)
-	if err != nil {
-		return dto.Record{}, err
-	}
-
-	response, err := c.waitResponse(udpConn, message.ID)
-	if err != nil {
-		return dto.Record{}, err
-	}
-
-	if len(response.Response) < 1 {
-		return dto.Record{}, &NoResponse{}
+// Resolve implements client.Client
+func (c *UDPClient) Resolve(ctx context.Context, request dto.Question) ([]dto.Record, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
 	}
-
-	return response.Response[0], nil
-}
-
-func (c *UDPClient) nextID() uint16 {
-	c.idMutex.Lock()
-	defer c.idMutex.Unlock()
-
-	c.id++
-	c.id = c.id % math.MaxUint16
-	return c.id
-}
-
-func (c *UDPClient) waitResponse(udpConn net.Conn, id uint16) (*dto.Message, error) {
-	buffer := c.getBuffer()
-	defer c.recycleBuffer(buffer)
-	_ = udpConn.SetReadDeadline(time.Now().Add(10 * time.Second))
-	n, err := udpConn.Read(buffer)
-	if err != nil {
-		return nil, err
+	var response *dto.Message
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		response, err = c.attempt(ctx, request, time.Until(deadline)/time.Duration(c.retries+1-attempt))
+		if !errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+			break
+		}
 	}
-	if n == 0 {
-		log.Println("client read 0 bytes")
+	if ctx.Err() != nil {
+		return nil, client.TransportFailure(ctx.Err())
 	}
-	data := buffer[0:n]
-	message, err := dto.ParseMessage(data)
 	if err != nil {
-		return nil, err
+		return nil, client.TransportFailure(err)
 	}
-	if id != message.ID {
-		return nil, errors.New("id mismatch")
+	if response.Header.TC() {
+		// the answer does not fit in a udp message, see rfc7766 section 5
+		return c.tcp.Resolve(ctx, request)
 	}
-	return message, nil
-}
-
-func (c *UDPClient) getConn() net.Conn {
-	return c.connexionPool.Get().(net.Conn)
-}
-
-func (c *UDPClient) recycleConn(conn net.Conn) {
-	c.connexionPool.Put(conn)
-}
-
-func (c *UDPClient) getBuffer() []byte {
-	return c.bufferPool.Get().([]byte)
+	return client.Answer(response)
 }
 
-func (c *UDPClient) recycleBuffer(buff []byte) {
-	c.bufferPool.Put(buff)
+// attempt sends the query once, a new id is used so that a late answer of a previous attempt is not mistaken for this one
+func (c *UDPClient) attempt(ctx context.Context, request dto.Question, timeout time.Duration) (*dto.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	query := client.NewQuery(client.RandomID(), request)
+	query.SetEDNS(dto.EDNS{UDPSize: dto.EDNSUDPLength})
+	return Exchange(ctx, c.address, &query)
 }
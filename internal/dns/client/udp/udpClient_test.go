@@ -1,10 +1,16 @@
 package udp
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
 
@@ -38,17 +44,19 @@ func TestUDPClient_ResolveV4(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewUDPClient(tt.fields.Address)
+			c := NewUDPClient(tt.fields.Address, 0)
 			got, err := c.ResolveV4(tt.args.name)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("UDPClient.ResolveV4() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if tt.wantEmpty && !reflect.DeepEqual(got, dto.Record{}) {
+			if tt.wantEmpty && len(got) != 0 {
 				t.Errorf("UDPClient.ResolveV4() = %v, want empty", got)
 			}
-			if nil == net.ParseIP(got.Data.String()).To4() {
-				t.Errorf("ip is not a V4, got %v", got.Data)
+			for _, record := range got {
+				if record.Type != dto.CNAME && nil == net.ParseIP(record.Data.String()).To4() {
+					t.Errorf("ip is not a V4, got %v", record.Data)
+				}
 			}
 		})
 	}
@@ -79,18 +87,194 @@ func TestUDPClient_ResolveV6(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewUDPClient(tt.fields.Address)
+			c := NewUDPClient(tt.fields.Address, 0)
 			got, err := c.ResolveV6(tt.args.name)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("UDPClient.ResolveV6() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if tt.wantempty && !reflect.DeepEqual(got, dto.Record{}) {
+			if tt.wantempty && len(got) != 0 {
 				t.Errorf("UDPClient.ResolveV6() = %v, want empty", got)
 			}
-			if nil == net.ParseIP(got.Data.String()).To16() {
-				t.Errorf("ip is not a V6, got %v", got.Data)
+			for _, record := range got {
+				if record.Type != dto.CNAME && nil == net.ParseIP(record.Data.String()).To16() {
+					t.Errorf("ip is not a V6, got %v", record.Data)
+				}
 			}
 		})
 	}
 }
+
+func TestUDPClient_ResolveCancelled(t *testing.T) {
+	// an upstream which never answers
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c := NewUDPClient(conn.LocalAddr().String(), 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = c.Resolve(ctx, dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("UDPClient.Resolve() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("UDPClient.Resolve() returned after %v", elapsed)
+	}
+}
+
+func TestUDPClient_ResolveTruncated(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	if err != nil {
+		t.Skip("the udp port matching the tcp one is not available", err)
+	}
+	defer conn.Close()
+
+	answers := []dto.Record{
+		{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{192, 0, 2, 1}},
+		{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{192, 0, 2, 2}},
+	}
+	// the udp answer is truncated, the whole answer is only sent over tcp
+	go func() {
+		buffer := make([]byte, dto.UDPMaxLength)
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		query, _ := dto.ParseMessage(buffer[:n])
+		response := dto.Message{ID: query.ID, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: query.Question}
+		response.Header.SetTC(true)
+		_, _ = conn.WriteTo(dto.SerializeMessage(response), addr)
+	}()
+	go func() {
+		stream, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+		var length uint16
+		_ = binary.Read(stream, binary.BigEndian, &length)
+		payload := make([]byte, length)
+		_, _ = io.ReadFull(stream, payload)
+		query, _ := dto.ParseMessage(payload)
+		response := dto.Message{ID: query.ID, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: query.Question, ResponseCount: 2, Response: answers}
+		out := dto.AppendMessage(make([]byte, 2, 512), &response)
+		binary.BigEndian.PutUint16(out, uint16(len(out)-2))
+		_, _ = stream.Write(out)
+	}()
+
+	c := NewUDPClient(listener.Addr().String(), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got, err := c.Resolve(ctx, dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN})
+	if err != nil || !reflect.DeepEqual(got, answers) {
+		t.Fatalf("UDPClient.Resolve() = %v, %v, want %v", got, err, answers)
+	}
+}
+
+// serveUDP answers each query with the packets returned by the handler, the query number starts at 0
+func serveUDP(t *testing.T, handler func(n int, query *dto.Message) []dto.Message) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buffer := make([]byte, dto.UDPMaxLength)
+		for n := 0; ; n++ {
+			size, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query, _ := dto.ParseMessage(buffer[:size])
+			for _, response := range handler(n, query) {
+				_, _ = conn.WriteTo(dto.SerializeMessage(response), addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func response(query *dto.Message, question dto.Question, ip dto.IPData) dto.Message {
+	return dto.Message{ID: query.ID, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: []dto.Question{question}, ResponseCount: 1,
+		Response: []dto.Record{{Name: question.Name, Type: question.Type, Class: question.Class, TTL: 60, Data: ip}}}
+}
+
+func TestUDPClient_ResolveMismatch(t *testing.T) {
+	address := serveUDP(t, func(_ int, query *dto.Message) []dto.Message {
+		wrongID := response(query, query.Question[0], dto.IPData{192, 0, 2, 1})
+		wrongID.ID++
+		wrongQuestion := response(query, dto.Question{Name: "forged.example", Type: dto.A, Class: dto.IN}, dto.IPData{192, 0, 2, 2})
+		notResponse := response(query, query.Question[0], dto.IPData{192, 0, 2, 3})
+		notResponse.Header = dto.STANDARD_QUERY
+		return []dto.Message{wrongID, wrongQuestion, notResponse, response(query, query.Question[0], dto.IPData{192, 0, 2, 4})}
+	})
+
+	c := NewUDPClient(address, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got, err := c.Resolve(ctx, dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN})
+	if err != nil || len(got) != 1 || got[0].Data.String() != "192.0.2.4" {
+		t.Fatalf("UDPClient.Resolve() = %v, %v, want the answer matching the query", got, err)
+	}
+}
+
+func TestUDPClient_ResolveRetries(t *testing.T) {
+	ids := make(chan uint16, 2)
+	address := serveUDP(t, func(n int, query *dto.Message) []dto.Message {
+		ids <- query.ID
+		if n == 0 {
+			return nil // the first query is lost
+		}
+		return []dto.Message{response(query, query.Question[0], dto.IPData{192, 0, 2, 1})}
+	})
+	question := dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := NewUDPClient(address, 1).Resolve(ctx, question); err != nil {
+		t.Fatalf("UDPClient.Resolve() error = %v, expecting the retry to be answered", err)
+	}
+	if first, second := <-ids, <-ids; first == second {
+		t.Errorf("the retry reused the id %d", first)
+	}
+}
+
+func TestUDPClient_ResolveNoRetry(t *testing.T) {
+	address := serveUDP(t, func(n int, query *dto.Message) []dto.Message {
+		if n == 0 {
+			return nil
+		}
+		return []dto.Message{response(query, query.Question[0], dto.IPData{192, 0, 2, 1})}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := NewUDPClient(address, 0).Resolve(ctx, dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("UDPClient.Resolve() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestUDPClient_ResolveRefused(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := NewUDPClient(address, 0).Resolve(ctx, dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}); !errors.Is(err, client.ErrRefused) {
+		t.Fatalf("UDPClient.Resolve() error = %v, want %v", err, client.ErrRefused)
+	}
+}
@@ -0,0 +1,70 @@
+package dto
+
+const (
+	ednsDOBit        = 1 << 15
+	ednsVersionShift = 16
+	ednsRCodeShift   = 24
+)
+
+// EDNS represents the content of the OPT pseudo-record, see rfc6891
+type EDNS struct {
+	UDPSize       uint16
+	ExtendedRCode uint8
+	Version       uint8
+	DO            bool
+	Options       []EDNSOption
+}
+
+// EDNSOption is a single option carried by the OPT pseudo-record
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// EDNS returns the EDNS information of the message, false if the message has no OPT record
+func (m *Message) EDNS() (EDNS, bool) {
+	for _, record := range m.Additional {
+		if record.Type == OPT {
+			return ednsFromRecord(record), true
+		}
+	}
+	return EDNS{}, false
+}
+
+// SetEDNS add the OPT record to the message, replacing the existing one if any
+func (m *Message) SetEDNS(edns EDNS) {
+	record := edns.record()
+	for i := range m.Additional {
+		if m.Additional[i].Type == OPT {
+			m.Additional[i] = record
+			return
+		}
+	}
+	m.Additional = append(m.Additional, record)
+	m.AdditionalCount = uint16(len(m.Additional))
+}
+
+func (e EDNS) record() Record {
+	ttl := uint32(e.ExtendedRCode)<<ednsRCodeShift | uint32(e.Version)<<ednsVersionShift
+	if e.DO {
+		ttl |= ednsDOBit
+	}
+	return Record{
+		Name:  "",
+		Type:  OPT,
+		Class: Class(e.UDPSize),
+		TTL:   ttl,
+		Data:  OPTData(e.Options),
+	}
+}
+
+func ednsFromRecord(record Record) EDNS {
+	options, _ := record.Data.(OPTData)
+	return EDNS{
+		UDPSize:       uint16(record.Class),
+		ExtendedRCode: uint8(record.TTL >> ednsRCodeShift),
+		Version:       uint8(record.TTL >> ednsVersionShift),
+		DO:            record.TTL&ednsDOBit != 0,
+		Options:       options,
+	}
+}
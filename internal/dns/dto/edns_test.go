@@ -0,0 +1,62 @@
+package dto_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestParseEDNS(t *testing.T) {
+	// example.com A query sent with a 1232 bytes buffer, DO bit and a cookie option
+	message, err := dto.ParseMessage(decodeString("04d201200001000000000001076578616d706c6503636f6d000001000100002904d000008000000c000a00080102030405060708"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edns, ok := message.EDNS()
+	if !ok {
+		t.Fatal("expecting an OPT record")
+	}
+	want := dto.EDNS{
+		UDPSize: 1232,
+		DO:      true,
+		Options: []dto.EDNSOption{{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+	}
+	if !reflect.DeepEqual(edns, want) {
+		t.Fatalf("EDNS() = %v, want %v", edns, want)
+	}
+}
+
+func TestSetEDNS(t *testing.T) {
+	message := dto.Message{
+		ID:            1,
+		Header:        0x8180,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "example.com", Type: dto.A, Class: dto.IN}},
+	}
+	if _, ok := message.EDNS(); ok {
+		t.Fatal("message should not have an OPT record")
+	}
+
+	message.SetEDNS(dto.EDNS{UDPSize: 4096})
+	want := dto.EDNS{UDPSize: 1232, ExtendedRCode: 1, Version: 0, DO: true, Options: []dto.EDNSOption{{Code: 8, Data: []byte{0, 1, 24, 0, 192, 0, 2}}}}
+	message.SetEDNS(want)
+	if message.AdditionalCount != 1 {
+		t.Fatalf("AdditionalCount = %d, want 1", message.AdditionalCount)
+	}
+
+	parsed, err := dto.ParseMessage(dto.SerializeMessage(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := parsed.EDNS()
+	if !ok {
+		t.Fatal("expecting an OPT record after round trip")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("EDNS() = %v, want %v", got, want)
+	}
+	if parsed.Additional[0].Name != "" {
+		t.Fatalf("OPT record should be owned by the root, got %q", parsed.Additional[0].Name)
+	}
+}
@@ -0,0 +1,124 @@
+package dto
+
+// Header represents the flags of a dns message header, see rfc1035 section 4.1.1
+type Header uint16
+
+// Opcode is the kind of query of a message
+type Opcode uint8
+
+// RCode is the response code of a message, extended to 12 bits by EDNS
+type RCode uint16
+
+const (
+	QUERY  Opcode = 0
+	IQUERY Opcode = 1
+	STATUS Opcode = 2
+	NOTIFY Opcode = 4
+	UPDATE Opcode = 5
+
+	NOERROR  RCode = 0
+	FORMERR  RCode = 1
+	SERVFAIL RCode = 2
+	NXDOMAIN RCode = 3
+	NOTIMP   RCode = 4
+	REFUSED  RCode = 5
+	BADVERS  RCode = 16
+)
+
+const (
+	qrFlag      Header = 0x8000
+	opcodeMask  Header = 0x7800
+	opcodeShift        = 11
+	aaFlag      Header = 0x0400
+	tcFlag      Header = 0x0200
+	rdFlag      Header = 0x0100
+	raFlag      Header = 0x0080
+	adFlag      Header = 0x0020
+	cdFlag      Header = 0x0010
+	rcodeMask   Header = 0x000f
+	rcodeBits          = 4
+)
+
+// QR returns true if the message is a response
+func (h Header) QR() bool { return h&qrFlag != 0 }
+
+// SetQR set the query/response flag
+func (h *Header) SetQR(v bool) { h.set(qrFlag, v) }
+
+// Opcode returns the kind of query of the message
+func (h Header) Opcode() Opcode { return Opcode((h & opcodeMask) >> opcodeShift) }
+
+// SetOpcode set the kind of query of the message
+func (h *Header) SetOpcode(o Opcode) {
+	*h = *h&^opcodeMask | Header(o)<<opcodeShift&opcodeMask
+}
+
+// AA returns true if the answer is authoritative
+func (h Header) AA() bool { return h&aaFlag != 0 }
+
+// SetAA set the authoritative answer flag
+func (h *Header) SetAA(v bool) { h.set(aaFlag, v) }
+
+// TC returns true if the message is truncated
+func (h Header) TC() bool { return h&tcFlag != 0 }
+
+// SetTC set the truncation flag
+func (h *Header) SetTC(v bool) { h.set(tcFlag, v) }
+
+// RD returns true if recursion is desired
+func (h Header) RD() bool { return h&rdFlag != 0 }
+
+// SetRD set the recursion desired flag
+func (h *Header) SetRD(v bool) { h.set(rdFlag, v) }
+
+// RA returns true if recursion is available
+func (h Header) RA() bool { return h&raFlag != 0 }
+
+// SetRA set the recursion available flag
+func (h *Header) SetRA(v bool) { h.set(raFlag, v) }
+
+// AD returns true if the data is authenticated
+func (h Header) AD() bool { return h&adFlag != 0 }
+
+// SetAD set the authentic data flag
+func (h *Header) SetAD(v bool) { h.set(adFlag, v) }
+
+// CD returns true if checking is disabled
+func (h Header) CD() bool { return h&cdFlag != 0 }
+
+// SetCD set the checking disabled flag
+func (h *Header) SetCD(v bool) { h.set(cdFlag, v) }
+
+// RCode returns the 4 bits response code of the header
+func (h Header) RCode() RCode { return RCode(h & rcodeMask) }
+
+// SetRCode set the 4 bits response code of the header
+func (h *Header) SetRCode(r RCode) {
+	*h = *h&^rcodeMask | Header(r)&rcodeMask
+}
+
+func (h *Header) set(flag Header, v bool) {
+	if v {
+		*h |= flag
+	} else {
+		*h &^= flag
+	}
+}
+
+// RCode returns the response code of the message, including the EDNS extended bits
+func (m *Message) RCode() RCode {
+	rcode := m.Header.RCode()
+	if edns, ok := m.EDNS(); ok {
+		rcode |= RCode(edns.ExtendedRCode) << rcodeBits
+	}
+	return rcode
+}
+
+// SetRCode set the response code of the message, the extended bits are stored in the OPT record if any
+func (m *Message) SetRCode(r RCode) {
+	m.Header.SetRCode(r)
+	if edns, ok := m.EDNS(); ok {
+		edns.ExtendedRCode = uint8(r >> rcodeBits)
+		m.SetEDNS(edns)
+	}
+}
@@ -0,0 +1,56 @@
+package dto_test
+
+import (
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestHeaderAccessors(t *testing.T) {
+	header := dto.Header(0x81b0) // QR RD RA AD CD
+	if !header.QR() || !header.RD() || !header.RA() || !header.AD() || !header.CD() {
+		t.Fatalf("missing flags in %x", uint16(header))
+	}
+	if header.AA() || header.TC() || header.Opcode() != dto.QUERY || header.RCode() != dto.NOERROR {
+		t.Fatalf("unexpected flags in %x", uint16(header))
+	}
+
+	header.SetAD(false)
+	header.SetCD(false)
+	if header != dto.STANDARD_RESPONSE {
+		t.Fatalf("expecting %x, got %x", uint16(dto.STANDARD_RESPONSE), uint16(header))
+	}
+
+	header.SetOpcode(dto.NOTIFY)
+	header.SetRCode(dto.NXDOMAIN)
+	header.SetAA(true)
+	header.SetTC(true)
+	if header.Opcode() != dto.NOTIFY || header.RCode() != dto.NXDOMAIN || !header.AA() || !header.TC() || !header.RD() {
+		t.Fatalf("unexpected header %x", uint16(header))
+	}
+	header.SetQR(false)
+	header.SetRA(false)
+	header.SetRD(false)
+	if header.QR() || header.RA() || header.RD() {
+		t.Fatalf("unexpected header %x", uint16(header))
+	}
+}
+
+func TestMessageRCode(t *testing.T) {
+	message := dto.Message{Header: dto.STANDARD_RESPONSE}
+	message.SetRCode(dto.BADVERS)
+	if message.RCode() != dto.NOERROR {
+		t.Fatalf("extended rcode can not be stored without EDNS, got %d", message.RCode())
+	}
+
+	message.SetEDNS(dto.EDNS{UDPSize: dto.EDNSUDPLength})
+	message.SetRCode(dto.BADVERS)
+	if message.RCode() != dto.BADVERS || message.Header.RCode() != dto.NOERROR {
+		t.Fatalf("expecting BADVERS, got %d", message.RCode())
+	}
+
+	message.SetRCode(dto.SERVFAIL)
+	if message.RCode() != dto.SERVFAIL {
+		t.Fatalf("expecting SERVFAIL, got %d", message.RCode())
+	}
+}
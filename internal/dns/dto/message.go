@@ -1,28 +1,43 @@
 package dto
 
-import "net"
-
 type Type uint16
 type Class uint16
 
 const (
-	A    Type = 1
-	AAAA Type = 28
+	A     Type = 1
+	NS    Type = 2
+	CNAME Type = 5
+	SOA   Type = 6
+	PTR   Type = 12
+	MX    Type = 15
+	TXT   Type = 16
+	AAAA  Type = 28
+	SRV   Type = 33
+	OPT   Type = 41
+	SVCB  Type = 64
+	HTTPS Type = 65
+	IXFR  Type = 251
+	AXFR  Type = 252
+	ANY   Type = 255
 
 	IN Class = 1
 
-	STANDARD_QUERY    uint16 = 0x0100
-	STANDARD_RESPONSE uint16 = 0x8180
+	STANDARD_QUERY    Header = 0x0100
+	STANDARD_RESPONSE Header = 0x8180
 )
 
 //Message represent a simplify dns message
 type Message struct {
-	ID            uint16
-	Header        uint16
-	QuestionCount uint16
-	ResponseCount uint16
-	Question      []Question
-	Response      []Record
+	ID              uint16
+	Header          Header
+	QuestionCount   uint16
+	ResponseCount   uint16
+	AuthorityCount  uint16
+	AdditionalCount uint16
+	Question        []Question
+	Response        []Record
+	Authority       []Record
+	Additional      []Record
 }
 
 //Question is a representation of a dns question
@@ -38,5 +53,5 @@ type Record struct {
 	Type  Type
 	Class Class
 	TTL   uint32
-	Data  net.IP
+	Data  RData
 }
@@ -3,225 +3,316 @@ package dto
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
-	"log"
 	"net"
 	"strconv"
-	"strings"
 )
 
 const (
-	BufferMaxLength     = 255
+	// BufferMaxLength maximum length of a dns message, limited by the tcp length prefix
+	BufferMaxLength = 65535
+	// UDPMaxLength maximum length of a dns message received over udp
+	UDPMaxLength = 4096
+	// ClassicUDPLength maximum length of a udp message when EDNS is not used
+	ClassicUDPLength = 512
+	// EDNSUDPLength udp payload size advertised when EDNS is used
+	EDNSUDPLength = 1232
+
 	bufferMinLength     = 12
 	bufferQuestionStart = 12
 
-	refStartByte = byte(192)
+	refStartByte     = labelTypeMask
+	maxPointerOffset = 0x3fff
 )
 
 var _ error = &BufferTooLongException{0}
 
-// ParseMessage parse a message from a binary representation
+// ParseMessage parse a message from a binary representation.
+// Malformed packets are reported with a FormatError
 func ParseMessage(packet []byte) (*Message, error) {
+	message := &Message{} //create an empty message, it will be filled in future
+	if err := ParseMessageInto(packet, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// ParseMessageInto parse a message from a binary representation into an existing message.
+// The sections, names and data of the message are reused when possible so parsing into the same message
+// again and again does not allocate: the content of a reused message must be copied to be kept.
+// The message content is undefined when an error is returned
+func ParseMessageInto(packet []byte, message *Message) error {
 	if len(packet) > BufferMaxLength {
-		return nil, &BufferTooLongException{len(packet)}
+		return &BufferTooLongException{len(packet)}
 	}
 	if len(packet) < bufferMinLength {
-		return nil, &BufferTooLongException{len(packet)}
+		return &FormatError{reason: "packet shorter than a header", offset: len(packet)}
 	}
-	message := &Message{} //create an empty message, it will be filled in future
-	if err := parseMetadata(packet, message); err != nil {
-		return nil, err
+	parseMetadata(packet, message)
+	r := reader{packet: packet, offset: bufferQuestionStart, end: len(packet)}
+	var err error
+	if message.Question, err = parseQuestions(&r, message.Question[:0], message.QuestionCount); err != nil {
+		return err
 	}
-	offset, err := parseQuestion(packet, message)
-	if err != nil {
-		return nil, err
+	if message.Response, err = parseRecords(&r, message.Response[:0], message.ResponseCount); err != nil {
+		return err
 	}
-	if err := parseResponse(packet, message, offset); err != nil {
-		return nil, err
+	if message.Authority, err = parseRecords(&r, message.Authority[:0], message.AuthorityCount); err != nil {
+		return err
 	}
-	return message, nil
+	if message.Additional, err = parseRecords(&r, message.Additional[:0], message.AdditionalCount); err != nil {
+		return err
+	}
+	return nil
 }
 
-func parseMetadata(packet []byte, message *Message) error {
+func parseMetadata(packet []byte, message *Message) {
 	message.ID = binary.BigEndian.Uint16(packet[0:2])
-	message.Header = binary.BigEndian.Uint16(packet[2:4])
+	message.Header = Header(binary.BigEndian.Uint16(packet[2:4]))
 	message.QuestionCount = binary.BigEndian.Uint16(packet[4:6])
 	message.ResponseCount = binary.BigEndian.Uint16(packet[6:8])
-	if binary.BigEndian.Uint16(packet[8:10]) != 0 {
-		log.Println(errors.New("authority rrs not supported"))
-	}
-	if binary.BigEndian.Uint16(packet[10:12]) != 0 {
-		log.Println(errors.New("additional rrs not supported"))
-	}
-	return nil
+	message.AuthorityCount = binary.BigEndian.Uint16(packet[8:10])
+	message.AdditionalCount = binary.BigEndian.Uint16(packet[10:12])
 }
 
-func parseQuestion(packet []byte, message *Message) (int, error) {
-	readedBytes := 0
-	buffer := bytes.NewBuffer(packet[bufferQuestionStart:])
-
-	for i := 0; i < int(message.QuestionCount); i++ {
-
-		question := Question{}
+// grow extend the slice by one element, the element previously stored at this position is kept to be reused
+func grow[T any](s []T) []T {
+	if len(s) < cap(s) {
+		return s[:len(s)+1]
+	}
+	var zero T
+	return append(s, zero)
+}
 
-		namestart, err := buffer.ReadByte()
-		if err != nil {
-			return 0, err
+func parseQuestions(r *reader, questions []Question, count uint16) ([]Question, error) {
+	for i := 0; i < int(count); i++ {
+		questions = grow(questions)
+		question := &questions[i]
+		if err := r.nameInto(&question.Name); err != nil {
+			return nil, err
 		}
-		question.Name, err = readName(namestart, buffer, packet)
+		t, err := r.uint16("question type")
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-
-		twoBytes := make([]byte, 2)
-		n, err := buffer.Read(twoBytes)
+		question.Type = Type(t)
+		class, err := r.uint16("question class")
 		if err != nil {
-			return 0, err
-		}
-		if n != 2 {
-			return 0, errors.New("bad read question Type")
+			return nil, err
 		}
-		question.Type = Type(binary.BigEndian.Uint16(twoBytes))
+		question.Class = Class(class)
+	}
+	return questions, nil
+}
 
-		n, err = buffer.Read(twoBytes)
-		if err != nil {
-			return 0, err
+// parseRecords read count records from the reader
+func parseRecords(r *reader, records []Record, count uint16) ([]Record, error) {
+	for i := 0; i < int(count); i++ {
+		records = grow(records)
+		if err := parseRecord(r, &records[i]); err != nil {
+			return nil, err
 		}
-		if n != 2 {
-			return 0, errors.New("bad read question class, read" + strconv.Itoa(n) + "bytes")
-		}
-		question.Class = Class(binary.BigEndian.Uint16(twoBytes))
-
-		message.Question = append(message.Question, question)
-		readedBytes += 6 + len(question.Name)
 	}
-	return readedBytes + bufferQuestionStart, nil
+	return records, nil
 }
 
-func parseResponse(packet []byte, message *Message, offset int) error {
-	buffer := bytes.NewBuffer(packet[offset:])
-
-	for i := 0; i < int(message.ResponseCount); i++ {
-		response := Record{}
+func parseRecord(r *reader, record *Record) error {
+	if err := r.nameInto(&record.Name); err != nil {
+		return err
+	}
+	t, err := r.uint16("record type")
+	if err != nil {
+		return err
+	}
+	record.Type = Type(t)
+	class, err := r.uint16("record class")
+	if err != nil {
+		return err
+	}
+	record.Class = Class(class)
+	if record.TTL, err = r.uint32("record TTL"); err != nil {
+		return err
+	}
+	dataLength, err := r.uint16("record data length")
+	if err != nil {
+		return err
+	}
+	if r.remaining() < int(dataLength) {
+		return r.formatError("record data out of the packet")
+	}
+	// the data is read with the reader limited to the data length, names can still point anywhere before
+	end := r.end
+	r.end = r.offset + int(dataLength)
+	if record.Data, err = parseData(r, record.Type, record.Data); err != nil {
+		return err
+	}
+	if r.remaining() != 0 {
+		return r.formatError("trailing bytes in record data")
+	}
+	r.end = end
+	return nil
+}
 
-		namestart, err := buffer.ReadByte()
+// parseData parse the data of a record, old is the data previously stored in the record, returned if unchanged
+func parseData(r *reader, t Type, old RData) (RData, error) {
+	switch t {
+	case A, AAAA:
+		return parseAddress(r, t, old)
+	case CNAME, NS, PTR:
+		return parseNameData(r, old)
+	case MX:
+		return parseMX(r, old)
+	case TXT:
+		return parseTXT(r, old)
+	case SOA:
+		return parseSOA(r, old)
+	case SRV:
+		return parseSRV(r, old)
+	case OPT:
+		return parseOPT(r, old)
+	default:
+		data, err := r.bytes(r.remaining(), "record data")
 		if err != nil {
-			return err
+			return nil, err
 		}
-		response.Name, err = readName(namestart, buffer, packet)
-		if err != nil {
-			return err
+		if o, ok := old.(RawData); ok && bytes.Equal(o, data) {
+			return old, nil
 		}
+		return RawData(bytes.Clone(data)), nil
+	}
+}
 
-		twoBytes := make([]byte, 2)
-		n, err := buffer.Read(twoBytes)
-		if err != nil {
-			return err
-		}
-		if n != 2 {
-			return errors.New("bad read response type")
-		}
-		response.Type = Type(binary.BigEndian.Uint16(twoBytes))
+func parseAddress(r *reader, t Type, old RData) (RData, error) {
+	if (t != A || r.remaining() != net.IPv4len) && (t != AAAA || r.remaining() != net.IPv6len) {
+		return nil, r.formatError("bad address length " + strconv.Itoa(r.remaining()) + " for type " + strconv.Itoa(int(t)))
+	}
+	data, _ := r.bytes(r.remaining(), "address")
+	if o, ok := old.(IPData); ok && len(o) == len(data) {
+		copy(o, data)
+		return old, nil
+	}
+	return IPData(bytes.Clone(data)), nil
+}
 
-		n, err = buffer.Read(twoBytes)
-		if err != nil {
-			return err
-		}
-		if n != 2 {
-			return errors.New("bad read response class")
-		}
-		response.Class = Class(binary.BigEndian.Uint16(twoBytes))
+func parseNameData(r *reader, old RData) (RData, error) {
+	o, ok := old.(NameData)
+	name := string(o)
+	if err := r.nameInto(&name); err != nil {
+		return nil, err
+	}
+	if ok && name == string(o) {
+		return old, nil
+	}
+	return NameData(name), nil
+}
 
-		ttlBuffer := make([]byte, 4)
-		n, err = buffer.Read(ttlBuffer)
-		if err != nil {
-			return err
-		}
-		if n != 4 {
-			return errors.New("bad read response TTL")
-		}
-		response.TTL = binary.BigEndian.Uint32(ttlBuffer)
+func parseMX(r *reader, old RData) (RData, error) {
+	o, ok := old.(MXData)
+	v := o
+	var err error
+	if v.Preference, err = r.uint16("MX preference"); err != nil {
+		return nil, err
+	}
+	if err = r.nameInto(&v.Exchange); err != nil {
+		return nil, err
+	}
+	if ok && v == o {
+		return old, nil
+	}
+	return v, nil
+}
 
-		n, err = buffer.Read(twoBytes)
+func parseTXT(r *reader, old RData) (RData, error) {
+	o, ok := old.(TXTData)
+	res := o[:0]
+	for r.remaining() > 0 {
+		length, err := r.uint8("TXT length")
 		if err != nil {
-			return err
-		}
-		if n != 2 {
-			return errors.New("bad read response data length")
+			return nil, err
 		}
-		dataLength := binary.BigEndian.Uint16(twoBytes)
-		data := make([]byte, dataLength)
-		n, err = buffer.Read(data)
+		s, err := r.bytes(int(length), "TXT data")
 		if err != nil {
-			return err
-		}
-		if n != int(dataLength) {
-			return errors.New("bad read response data")
+			return nil, err
 		}
-
-		response.Data, err = parseAddress(data, response.Type)
-		if err != nil {
-			return err
+		res = grow(res)
+		if res[len(res)-1] != string(s) {
+			res[len(res)-1] = string(s)
 		}
-
-		message.Response = append(message.Response, response)
 	}
-
-	return nil
+	if ok && len(res) == len(o) && (len(o) == 0 || &res[0] == &o[0]) {
+		return old, nil // updated in place
+	}
+	return res, nil
 }
 
-func readName(namestart byte, buffer *bytes.Buffer, packet []byte) (string, error) {
-	if namestart == refStartByte {
-		ref, err := buffer.ReadByte()
-		if err != nil {
-			return "", err
-		}
-		parts := make([]byte, 2)
-		parts[0] = namestart
-		parts[1] = ref
-		return parseName(parts, packet), nil
+func parseSOA(r *reader, old RData) (RData, error) {
+	o, ok := old.(SOAData)
+	v := o
+	if err := r.nameInto(&v.MName); err != nil {
+		return nil, err
 	}
-	parts, err := buffer.ReadBytes(0)
-	parts = parts[0 : len(parts)-1]
-	if err != nil {
-		return "", err
+	if err := r.nameInto(&v.RName); err != nil {
+		return nil, err
 	}
-	allParts := make([]byte, 0, len(parts)+1)
-	allParts = append(allParts, namestart)
-	allParts = append(allParts, parts...)
-	return parseName(allParts, packet), nil
-}
-
-func parseName(parts []byte, packet []byte) string {
-	start := parts[0]
-	if start == refStartByte {
-		buffer := bytes.NewBuffer(packet[parts[1]:])
-		bytes, _ := buffer.ReadBytes(0)
-		return parseAllParts(bytes[0 : len(bytes)-1])
+	if r.remaining() != 20 {
+		return nil, r.formatError("bad read SOA data")
+	}
+	fields, _ := r.bytes(20, "SOA data")
+	v.Serial = binary.BigEndian.Uint32(fields[0:4])
+	v.Refresh = binary.BigEndian.Uint32(fields[4:8])
+	v.Retry = binary.BigEndian.Uint32(fields[8:12])
+	v.Expire = binary.BigEndian.Uint32(fields[12:16])
+	v.Minimum = binary.BigEndian.Uint32(fields[16:20])
+	if ok && v == o {
+		return old, nil
 	}
-	return parseAllParts(parts)
+	return v, nil
 }
 
-func parseAllParts(parts []byte) string {
-	var sb strings.Builder
-	size := int(parts[0])
-	sb.Write(parts[1 : size+1])
-	if len(parts) > size+1 {
-		sb.WriteRune('.')
-		sb.WriteString(parseAllParts(parts[size+1:]))
+func parseSRV(r *reader, old RData) (RData, error) {
+	o, ok := old.(SRVData)
+	v := o
+	fields, err := r.bytes(6, "SRV data")
+	if err != nil {
+		return nil, err
+	}
+	v.Priority = binary.BigEndian.Uint16(fields[0:2])
+	v.Weight = binary.BigEndian.Uint16(fields[2:4])
+	v.Port = binary.BigEndian.Uint16(fields[4:6])
+	if err := r.nameInto(&v.Target); err != nil {
+		return nil, err
 	}
-	return sb.String()
+	if ok && v == o {
+		return old, nil
+	}
+	return v, nil
 }
 
-func parseAddress(data []byte, t Type) (net.IP, error) {
-	if t == A && len(data) == net.IPv4len {
-		return net.IP(data), nil
+func parseOPT(r *reader, old RData) (RData, error) {
+	o, ok := old.(OPTData)
+	res := o[:0]
+	for r.remaining() > 0 {
+		code, err := r.uint16("OPT option code")
+		if err != nil {
+			return nil, err
+		}
+		length, err := r.uint16("OPT option length")
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.bytes(int(length), "OPT option data")
+		if err != nil {
+			return nil, err
+		}
+		res = grow(res)
+		option := &res[len(res)-1]
+		if option.Code != code || !bytes.Equal(option.Data, data) {
+			*option = EDNSOption{Code: code, Data: bytes.Clone(data)}
+		}
 	}
-
-	if t == AAAA && len(data) == net.IPv6len {
-		return net.IP(data), nil
+	if ok && len(res) == len(o) && (len(o) == 0 || &res[0] == &o[0]) {
+		return old, nil // updated in place
 	}
-	return nil, errors.New("bad response type")
+	return res, nil
 }
 
 // BufferTooLongException error returned when the buffer is too long
@@ -231,5 +322,5 @@ type BufferTooLongException struct {
 
 // Error returns the string of the current error
 func (b *BufferTooLongException) Error() string {
-	return "the length of the buffer" + strconv.Itoa(b.len) + "is too long, maximum length is " + strconv.Itoa(BufferMaxLength)
+	return "the length of the buffer " + strconv.Itoa(b.len) + " is too long, maximum length is " + strconv.Itoa(BufferMaxLength)
 }
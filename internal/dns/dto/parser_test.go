@@ -1,8 +1,12 @@
 package dto_test
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/bluguard/dnshield/internal/dns/dto"
@@ -17,15 +21,17 @@ func decodeString(s string) []byte {
 }
 
 type testCase struct {
-	name string
-	in   []byte
-	out  dto.Message
+	name       string
+	in         []byte
+	out        dto.Message
+	compressed bool // in is the compressed serialization of out
 }
 
 var parseTest = []testCase{
 	{
-		name: "google.fr request type A",
-		in:   decodeString("da500100000100000000000006676f6f676c650266720000010001"),
+		name:       "google.fr request type A",
+		compressed: true,
+		in:         decodeString("da500100000100000000000006676f6f676c650266720000010001"),
 		out: dto.Message{
 			ID:            55888,
 			Header:        256,
@@ -35,8 +41,9 @@ var parseTest = []testCase{
 		},
 	},
 	{
-		name: "google.fr request type AAAA",
-		in:   decodeString("e55d0100000100000000000006676f6f676c6502667200001c0001"),
+		name:       "google.fr request type AAAA",
+		compressed: true,
+		in:         decodeString("e55d0100000100000000000006676f6f676c6502667200001c0001"),
 		out: dto.Message{
 			ID:            58717,
 			Header:        256,
@@ -47,8 +54,9 @@ var parseTest = []testCase{
 	},
 
 	{
-		name: "google.com response type A",
-		in:   decodeString("96a68180000100010000000006676f6f676c6503636f6d0000010001c00c00010001000000d400048efab8ce"),
+		name:       "google.com response type A",
+		compressed: true,
+		in:         decodeString("96a68180000100010000000006676f6f676c6503636f6d0000010001c00c00010001000000d400048efab8ce"),
 
 		out: dto.Message{
 			ID:            0x96a6,
@@ -56,12 +64,13 @@ var parseTest = []testCase{
 			QuestionCount: 1,
 			ResponseCount: 1,
 			Question:      []dto.Question{{Name: "google.com", Type: dto.A, Class: dto.IN}},
-			Response:      []dto.Record{{Name: "google.com", Type: dto.A, Class: dto.IN, TTL: 212, Data: (net.ParseIP("142.250.184.206").To4())}},
+			Response:      []dto.Record{{Name: "google.com", Type: dto.A, Class: dto.IN, TTL: 212, Data: dto.IPData(net.ParseIP("142.250.184.206").To4())}},
 		},
 	},
 	{
-		name: "google.com response type AAAA",
-		in:   decodeString("80a48180000100010000000006676f6f676c6503636f6d00001c0001c00c001c00010000003200102a00145040010830000000000000200e"),
+		name:       "google.com response type AAAA",
+		compressed: true,
+		in:         decodeString("80a48180000100010000000006676f6f676c6503636f6d00001c0001c00c001c00010000003200102a00145040010830000000000000200e"),
 
 		out: dto.Message{
 			ID:            0x80a4,
@@ -69,7 +78,7 @@ var parseTest = []testCase{
 			QuestionCount: 1,
 			ResponseCount: 1,
 			Question:      []dto.Question{{Name: "google.com", Type: dto.AAAA, Class: dto.IN}},
-			Response:      []dto.Record{{Name: "google.com", Type: dto.AAAA, Class: dto.IN, TTL: 50, Data: (net.ParseIP("2a00:1450:4001:830::200e").To16())}},
+			Response:      []dto.Record{{Name: "google.com", Type: dto.AAAA, Class: dto.IN, TTL: 50, Data: dto.IPData(net.ParseIP("2a00:1450:4001:830::200e").To16())}},
 		},
 	},
 	{
@@ -81,7 +90,121 @@ var parseTest = []testCase{
 			QuestionCount: 1,
 			ResponseCount: 1,
 			Question:      []dto.Question{{Name: "youtube.com", Type: dto.A, Class: dto.IN}},
-			Response:      []dto.Record{{Name: "youtube.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: (net.ParseIP("142.250.201.14").To4())}},
+			Response:      []dto.Record{{Name: "youtube.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData(net.ParseIP("142.250.201.14").To4())}},
+		},
+	},
+	{
+		name:       "www.example.com response with compressed CNAME",
+		compressed: true,
+		in:         decodeString("12348180000100020000000003777777076578616d706c6503636f6d0000010001c00c000500010000012c00070465646765c010c02d000100010000003c00045db8d822"),
+		out: dto.Message{
+			ID:            0x1234,
+			Header:        0x8180,
+			QuestionCount: 1,
+			ResponseCount: 2,
+			Question:      []dto.Question{{Name: "www.example.com", Type: dto.A, Class: dto.IN}},
+			Response: []dto.Record{
+				{Name: "www.example.com", Type: dto.CNAME, Class: dto.IN, TTL: 300, Data: dto.NameData("edge.example.com")},
+				{Name: "edge.example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData(net.ParseIP("93.184.216.34").To4())},
+			},
+		},
+	},
+	{
+		name:       "nxdomain.example.com response with authority and additional",
+		compressed: true,
+		in:         decodeString("beef81830001000000010001086e78646f6d61696e076578616d706c6503636f6d0000010001c015000600010000012c001f026e73c015036e6f63c01578a3f17500001c2000000e10001275000000012cc032000100010000012c0004c0000201"),
+		out: dto.Message{
+			ID:              0xbeef,
+			Header:          0x8183,
+			QuestionCount:   1,
+			ResponseCount:   0,
+			AuthorityCount:  1,
+			AdditionalCount: 1,
+			Question:        []dto.Question{{Name: "nxdomain.example.com", Type: dto.A, Class: dto.IN}},
+			Authority: []dto.Record{{Name: "example.com", Type: dto.SOA, Class: dto.IN, TTL: 300, Data: dto.SOAData{
+				MName: "ns.example.com", RName: "noc.example.com", Serial: 2024010101, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 300,
+			}}},
+			Additional: []dto.Record{{Name: "ns.example.com", Type: dto.A, Class: dto.IN, TTL: 300, Data: dto.IPData(net.ParseIP("192.0.2.1").To4())}},
+		},
+	},
+}
+
+var serializeTest = []testCase{
+	{
+		name: "example.com MX",
+		out: dto.Message{
+			ID:            1,
+			Header:        0x8180,
+			QuestionCount: 1,
+			ResponseCount: 2,
+			Question:      []dto.Question{{Name: "example.com", Type: dto.MX, Class: dto.IN}},
+			Response: []dto.Record{
+				{Name: "example.com", Type: dto.MX, Class: dto.IN, TTL: 300, Data: dto.MXData{Preference: 10, Exchange: "mx1.example.com"}},
+				{Name: "example.com", Type: dto.MX, Class: dto.IN, TTL: 300, Data: dto.MXData{Preference: 20, Exchange: "mx2.example.com"}},
+			},
+		},
+	},
+	{
+		name: "example.com TXT",
+		out: dto.Message{
+			ID:            2,
+			Header:        0x8180,
+			QuestionCount: 1,
+			ResponseCount: 1,
+			Question:      []dto.Question{{Name: "example.com", Type: dto.TXT, Class: dto.IN}},
+			Response:      []dto.Record{{Name: "example.com", Type: dto.TXT, Class: dto.IN, TTL: 300, Data: dto.TXTData{"v=spf1 -all", ""}}},
+		},
+	},
+	{
+		name: "example.com SOA",
+		out: dto.Message{
+			ID:            3,
+			Header:        0x8180,
+			QuestionCount: 1,
+			ResponseCount: 1,
+			Question:      []dto.Question{{Name: "example.com", Type: dto.SOA, Class: dto.IN}},
+			Response: []dto.Record{{Name: "example.com", Type: dto.SOA, Class: dto.IN, TTL: 3600, Data: dto.SOAData{
+				MName: "ns.icann.org", RName: "noc.dns.icann.org", Serial: 2022091169, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 3600,
+			}}},
+		},
+	},
+	{
+		name: "_sip._tcp.example.com SRV",
+		out: dto.Message{
+			ID:            4,
+			Header:        0x8180,
+			QuestionCount: 1,
+			ResponseCount: 1,
+			Question:      []dto.Question{{Name: "_sip._tcp.example.com", Type: dto.SRV, Class: dto.IN}},
+			Response:      []dto.Record{{Name: "_sip._tcp.example.com", Type: dto.SRV, Class: dto.IN, TTL: 86400, Data: dto.SRVData{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com"}}},
+		},
+	},
+	{
+		name: "example.com NS and unknown type",
+		out: dto.Message{
+			ID:            5,
+			Header:        0x8180,
+			QuestionCount: 1,
+			ResponseCount: 2,
+			Question:      []dto.Question{{Name: "example.com", Type: dto.NS, Class: dto.IN}},
+			Response: []dto.Record{
+				{Name: "example.com", Type: dto.NS, Class: dto.IN, TTL: 86400, Data: dto.NameData("a.iana-servers.net")},
+				{Name: "example.com", Type: dto.Type(99), Class: dto.IN, TTL: 86400, Data: dto.RawData{1, 2, 3}},
+			},
+		},
+	},
+	{
+		name: "www.www.example.com repeated label",
+		out: dto.Message{
+			ID:            6,
+			Header:        0x8180,
+			QuestionCount: 1,
+			ResponseCount: 2,
+			Question:      []dto.Question{{Name: "www.www.example.com", Type: dto.NS, Class: dto.IN}},
+			Response: []dto.Record{
+				{Name: "www.www.example.com", Type: dto.NS, Class: dto.IN, TTL: 300, Data: dto.NameData("ns.ns.example.com")},
+				{Name: "www.www.example.com", Type: dto.NS, Class: dto.IN, TTL: 300, Data: dto.NameData("a.a")},
+			},
 		},
 	},
 }
@@ -104,12 +227,25 @@ func testParse(in []byte, out dto.Message, t *testing.T) {
 
 func TestSerializeRequest(t *testing.T) {
 
-	for _, test := range parseTest {
+	for _, test := range append(parseTest, serializeTest...) {
 		t.Run(test.name, func(t2 *testing.T) { testSerialize(test.out, t2) })
 	}
 
 }
 
+func TestSerializeCompression(t *testing.T) {
+	for _, test := range parseTest {
+		if !test.compressed {
+			continue
+		}
+		t.Run(test.name, func(t2 *testing.T) {
+			if got := dto.SerializeMessage(test.out); !bytes.Equal(got, test.in) {
+				t2.Fatalf("SerializeMessage() = %x, want %x", got, test.in)
+			}
+		})
+	}
+}
+
 func testSerialize(message dto.Message, t *testing.T) {
 	m, err := dto.ParseMessage(dto.SerializeMessage(message))
 	if err != nil {
@@ -146,11 +282,23 @@ func assertMessageEquals(message *dto.Message, out dto.Message, t *testing.T) {
 			t.Fatal("missmatch question Type")
 		}
 	}
-	if len(message.Response) != len(out.Response) {
-		t.Fatal("Question number missmatch")
+	if message.AuthorityCount != out.AuthorityCount {
+		t.Fatal("missmatch AuthorityCount")
+	}
+	if message.AdditionalCount != out.AdditionalCount {
+		t.Fatal("missmatch AdditionalCount")
 	}
-	for i, response := range message.Response {
-		o := out.Response[i]
+	assertRecordsEquals(message.Response, out.Response, t)
+	assertRecordsEquals(message.Authority, out.Authority, t)
+	assertRecordsEquals(message.Additional, out.Additional, t)
+}
+
+func assertRecordsEquals(records []dto.Record, out []dto.Record, t *testing.T) {
+	if len(records) != len(out) {
+		t.Fatal("Record number missmatch")
+	}
+	for i, response := range records {
+		o := out[i]
 		if response.Name != o.Name {
 			t.Fatal("missmatch response name")
 		}
@@ -163,12 +311,66 @@ func assertMessageEquals(message *dto.Message, out dto.Message, t *testing.T) {
 		if response.TTL != o.TTL {
 			t.Fatal("missmatch TTL")
 		}
-		if !net.IP.Equal(o.Data, response.Data) {
+		if !reflect.DeepEqual(o.Data, response.Data) {
 			t.Fatal("mismatch data")
 		}
 	}
 }
 
+var malformedTest = []testCase{
+	{name: "self pointer", in: decodeString("000101000001000000000000c00c00010001")},
+	{name: "forward pointer", in: decodeString("000101000001000000000000c01400010001016100")},
+	{name: "pointer loop through a label", in: decodeString("0001010000010000000000000161c00c00010001")},
+	{name: "truncated label", in: decodeString("00010100000100000000000005616263")},
+	{name: "name too long", in: decodeString("0001010000010000000000003f" + strings.Repeat("61", 63) + "3f" + strings.Repeat("61", 63) + "3f" + strings.Repeat("61", 63) + "3f" + strings.Repeat("61", 63) + "0000010001")},
+	{name: "extended label type", in: decodeString("000101000001000000000000416162630000010001")},
+	{name: "missing question type", in: decodeString("000101000001000000000000076578616d706c6503636f6d0000")},
+	{name: "record data out of the packet", in: decodeString("000101000001000100000000016101620000010001c00c000100010000003c00c801020304")},
+	{name: "bad address length", in: decodeString("000101000001000100000000016101620000010001c00c000100010000003c0003010203")},
+	{name: "name overflowing record data", in: decodeString("000101000001000100000000016101620000010001c00c000500010000003c00020361626300")},
+	{name: "hostile counts", in: decodeString("00010100ffffffffffffffff016101620000010001")},
+	{name: "short packet", in: decodeString("000101")},
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, test := range malformedTest {
+		t.Run(test.name, func(t2 *testing.T) {
+			message, err := dto.ParseMessage(test.in)
+			if err == nil {
+				t2.Fatalf("expecting an error, got %v", message)
+			}
+			var formatError *dto.FormatError
+			if !errors.As(err, &formatError) {
+				t2.Fatalf("expecting a FormatError, got %v", err)
+			}
+			if formatError.RCode() != dto.FORMERR {
+				t2.Fatalf("expecting FORMERR, got %v", formatError.RCode())
+			}
+		})
+	}
+}
+
+func FuzzParseMessage(f *testing.F) {
+	for _, test := range parseTest {
+		f.Add(test.in)
+	}
+	for _, test := range malformedTest {
+		f.Add(test.in)
+	}
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		message, err := dto.ParseMessage(packet)
+		if err != nil {
+			return
+		}
+		if len(message.Question) != int(message.QuestionCount) || len(message.Response) != int(message.ResponseCount) ||
+			len(message.Authority) != int(message.AuthorityCount) || len(message.Additional) != int(message.AdditionalCount) {
+			t.Fatalf("sections do not match the header counts %v", message)
+		}
+		// a parsed message must be serializable
+		_ = dto.SerializeTruncated(*message, dto.ClassicUDPLength)
+	})
+}
+
 var benchCase = testCase{
 	name: "google.com response type A",
 	in:   decodeString("96a68180000100010000000006676f6f676c6503636f6d0000010001c00c00010001000000d400048efab8ce"),
@@ -179,7 +381,7 @@ var benchCase = testCase{
 		QuestionCount: 1,
 		ResponseCount: 1,
 		Question:      []dto.Question{{Name: "google.com", Type: dto.A, Class: dto.IN}},
-		Response:      []dto.Record{{Name: "google.com", Type: dto.A, Class: dto.IN, TTL: 212, Data: net.ParseIP("142.250.186.46")}},
+		Response:      []dto.Record{{Name: "google.com", Type: dto.A, Class: dto.IN, TTL: 212, Data: dto.IPData(net.ParseIP("142.250.186.46"))}},
 	},
 }
 
@@ -188,3 +390,107 @@ func BenchmarkParser(b *testing.B) {
 		dto.ParseMessage(benchCase.in)
 	}
 }
+
+func TestSerializeTruncated(t *testing.T) {
+	message := dto.Message{
+		ID:              7,
+		Header:          0x8180,
+		QuestionCount:   1,
+		ResponseCount:   100,
+		AuthorityCount:  1,
+		AdditionalCount: 1,
+		Question:        []dto.Question{{Name: "many.example.com", Type: dto.A, Class: dto.IN}},
+		Authority:       []dto.Record{{Name: "example.com", Type: dto.NS, Class: dto.IN, TTL: 300, Data: dto.NameData("ns.example.com")}},
+		Additional:      []dto.Record{{Name: "ns.example.com", Type: dto.A, Class: dto.IN, TTL: 300, Data: dto.IPData{192, 0, 2, 1}}},
+	}
+	for i := 0; i < 100; i++ {
+		message.Response = append(message.Response, dto.Record{Name: "many.example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: dto.IPData{10, 0, byte(i / 256), byte(i)}})
+	}
+	message.SetEDNS(dto.EDNS{UDPSize: dto.EDNSUDPLength})
+
+	full, err := dto.ParseMessage(dto.SerializeTruncated(message, dto.UDPMaxLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Header.TC() || full.ResponseCount != 100 || full.AdditionalCount != 2 {
+		t.Fatal("message fitting in the buffer should not be truncated")
+	}
+
+	payload := dto.SerializeTruncated(message, dto.ClassicUDPLength)
+	if len(payload) > dto.ClassicUDPLength {
+		t.Fatalf("truncated payload is %d bytes long", len(payload))
+	}
+	parsed, err := dto.ParseMessage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Header.TC() {
+		t.Fatal("TC bit should be set")
+	}
+	if parsed.AuthorityCount != 0 || parsed.ResponseCount == 0 || parsed.ResponseCount == 100 {
+		t.Fatalf("unexpected sections after truncation %d answers %d authorities", parsed.ResponseCount, parsed.AuthorityCount)
+	}
+	if _, ok := parsed.EDNS(); !ok || parsed.AdditionalCount != 1 {
+		t.Fatal("OPT record should be the only additional record kept")
+	}
+	if len(message.Response) != 100 {
+		t.Fatal("truncation should not alter the given message")
+	}
+}
+
+func BenchmarkParseMessageInto(b *testing.B) {
+	b.ReportAllocs()
+	message := &dto.Message{}
+	for i := 0; i < b.N; i++ {
+		_ = dto.ParseMessageInto(benchCase.in, message)
+	}
+}
+
+func BenchmarkSerializer(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dto.SerializeMessage(benchCase.out)
+	}
+}
+
+func BenchmarkAppendMessage(b *testing.B) {
+	b.ReportAllocs()
+	buffer := make([]byte, 0, dto.ClassicUDPLength)
+	for i := 0; i < b.N; i++ {
+		buffer = dto.AppendMessage(buffer[:0], &benchCase.out)
+	}
+}
+
+func TestParseMessageIntoReuse(t *testing.T) {
+	message := &dto.Message{}
+	for _, test := range parseTest {
+		// parsing into a message holding another content must give the same result than a fresh parse
+		if err := dto.ParseMessageInto(test.in, message); err != nil {
+			t.Fatal(err)
+		}
+		assertMessageEquals(message, test.out, t)
+	}
+
+	for _, test := range parseTest {
+		allocs := testing.AllocsPerRun(10, func() {
+			if err := dto.ParseMessageInto(test.in, message); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("%s: parsing again into the same message allocates %v times", test.name, allocs)
+		}
+	}
+}
+
+func TestAppendMessageAllocations(t *testing.T) {
+	buffer := make([]byte, 0, dto.UDPMaxLength)
+	for _, test := range append(parseTest, serializeTest...) {
+		allocs := testing.AllocsPerRun(10, func() {
+			buffer = dto.AppendMessage(buffer[:0], &test.out)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: serializing allocates %v times", test.name, allocs)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package dto
+
+import (
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	_ RData = IPData{}
+	_ RData = NameData("")
+	_ RData = MXData{}
+	_ RData = TXTData{}
+	_ RData = SOAData{}
+	_ RData = SRVData{}
+	_ RData = OPTData{}
+	_ RData = RawData{}
+)
+
+// RData is the type specific payload of a record
+type RData interface {
+	String() string
+}
+
+// IPData is the payload of A and AAAA records
+type IPData net.IP
+
+// String implements RData
+func (d IPData) String() string {
+	return net.IP(d).String()
+}
+
+// NameData is the payload of CNAME, NS and PTR records
+type NameData string
+
+// String implements RData
+func (d NameData) String() string {
+	return string(d)
+}
+
+// MXData is the payload of MX records
+type MXData struct {
+	Preference uint16
+	Exchange   string
+}
+
+// String implements RData
+func (d MXData) String() string {
+	return strconv.Itoa(int(d.Preference)) + " " + d.Exchange
+}
+
+// TXTData is the payload of TXT records, one entry per character-string
+type TXTData []string
+
+// String implements RData
+func (d TXTData) String() string {
+	quoted := make([]string, 0, len(d))
+	for _, s := range d {
+		quoted = append(quoted, strconv.Quote(s))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// SOAData is the payload of SOA records
+type SOAData struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+// String implements RData
+func (d SOAData) String() string {
+	return d.MName + " " + d.RName + " " +
+		strconv.FormatUint(uint64(d.Serial), 10) + " " +
+		strconv.FormatUint(uint64(d.Refresh), 10) + " " +
+		strconv.FormatUint(uint64(d.Retry), 10) + " " +
+		strconv.FormatUint(uint64(d.Expire), 10) + " " +
+		strconv.FormatUint(uint64(d.Minimum), 10)
+}
+
+// SRVData is the payload of SRV records
+type SRVData struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// String implements RData
+func (d SRVData) String() string {
+	return strconv.Itoa(int(d.Priority)) + " " + strconv.Itoa(int(d.Weight)) + " " + strconv.Itoa(int(d.Port)) + " " + d.Target
+}
+
+// OPTData is the payload of the OPT pseudo-record, the list of EDNS options
+type OPTData []EDNSOption
+
+// String implements RData
+func (d OPTData) String() string {
+	options := make([]string, 0, len(d))
+	for _, o := range d {
+		options = append(options, strconv.Itoa(int(o.Code))+":"+hex.EncodeToString(o.Data))
+	}
+	return strings.Join(options, " ")
+}
+
+// RawData is the payload of records of a type without a dedicated representation
+type RawData []byte
+
+// String implements RData
+func (d RawData) String() string {
+	return hex.EncodeToString(d)
+}
@@ -0,0 +1,158 @@
+package dto
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+const (
+	maxNameLength = 255 // maximum wire length of a name, see rfc1035 section 3.1
+	maxPointers   = 127 // a valid name can not contain more pointers than labels
+
+	labelTypeMask  = byte(0xc0)
+	labelTypeBasic = byte(0x00)
+)
+
+var _ error = &FormatError{}
+
+// FormatError error returned when a packet is malformed, the server should answer with FORMERR
+type FormatError struct {
+	reason string
+	offset int
+}
+
+// Error implements error
+func (e *FormatError) Error() string {
+	return "malformed packet at offset " + strconv.Itoa(e.offset) + ": " + e.reason
+}
+
+// RCode returns the response code matching the error
+func (e *FormatError) RCode() RCode {
+	return FORMERR
+}
+
+// reader read the fields of a packet, every read is bounds checked
+type reader struct {
+	packet  []byte
+	offset  int
+	end     int // reads are limited to this offset, except when following a pointer
+	scratch [maxNameLength]byte
+}
+
+func (r *reader) formatError(reason string) error {
+	return &FormatError{reason: reason, offset: r.offset}
+}
+
+func (r *reader) remaining() int {
+	return r.end - r.offset
+}
+
+func (r *reader) uint8(field string) (uint8, error) {
+	if r.remaining() < 1 {
+		return 0, r.formatError("bad read " + field)
+	}
+	v := r.packet[r.offset]
+	r.offset++
+	return v, nil
+}
+
+func (r *reader) uint16(field string) (uint16, error) {
+	if r.remaining() < 2 {
+		return 0, r.formatError("bad read " + field)
+	}
+	v := binary.BigEndian.Uint16(r.packet[r.offset:])
+	r.offset += 2
+	return v, nil
+}
+
+func (r *reader) uint32(field string) (uint32, error) {
+	if r.remaining() < 4 {
+		return 0, r.formatError("bad read " + field)
+	}
+	v := binary.BigEndian.Uint32(r.packet[r.offset:])
+	r.offset += 4
+	return v, nil
+}
+
+// bytes returns the next n bytes, the slice shares the memory of the packet
+func (r *reader) bytes(n int, field string) ([]byte, error) {
+	if n < 0 || r.remaining() < n {
+		return nil, r.formatError("bad read " + field)
+	}
+	v := r.packet[r.offset : r.offset+n]
+	r.offset += n
+	return v, nil
+}
+
+// nameInto decode the name at the current offset into dst.
+// dst is kept when it already holds the decoded name, avoiding an allocation
+func (r *reader) nameInto(dst *string) error {
+	name, err := r.name()
+	if err != nil {
+		return err
+	}
+	if *dst != string(name) {
+		*dst = string(name)
+	}
+	return nil
+}
+
+// name decode the name at the current offset, following compression pointers.
+// Pointers must point before themselves and the decoded name can not exceed 255 bytes, so loops are rejected.
+// The returned slice is only valid until the next call
+func (r *reader) name() ([]byte, error) {
+	name := r.scratch[:0]
+	offset := r.offset
+	limit := r.end
+	end := -1 // offset following the name in the packet, known once the first pointer is met
+	wireLength := 1
+	pointers := 0
+
+	for {
+		if offset >= limit {
+			return nil, &FormatError{reason: "name out of the packet", offset: offset}
+		}
+		length := r.packet[offset]
+		switch length & labelTypeMask {
+		case labelTypeBasic:
+			if length == 0 {
+				if end < 0 {
+					end = offset + 1
+				}
+				r.offset = end
+				return name, nil
+			}
+			if offset+1+int(length) > limit {
+				return nil, &FormatError{reason: "label out of the packet", offset: offset}
+			}
+			wireLength += int(length) + 1
+			if wireLength > maxNameLength {
+				return nil, &FormatError{reason: "name too long", offset: offset}
+			}
+			if len(name) > 0 {
+				name = append(name, '.')
+			}
+			name = append(name, r.packet[offset+1:offset+1+int(length)]...)
+			offset += 1 + int(length)
+		case labelTypeMask:
+			if offset+2 > limit {
+				return nil, &FormatError{reason: "pointer out of the packet", offset: offset}
+			}
+			target := int(binary.BigEndian.Uint16(r.packet[offset:]) & maxPointerOffset)
+			if target >= offset {
+				return nil, &FormatError{reason: "forward or looping pointer", offset: offset}
+			}
+			pointers++
+			if pointers > maxPointers {
+				return nil, &FormatError{reason: "too many pointers", offset: offset}
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = target
+			limit = len(r.packet)
+		default:
+			return nil, &FormatError{reason: "unsupported label type", offset: offset}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package dto
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	reverseV4Suffix = ".in-addr.arpa"
+	reverseV6Suffix = ".ip6.arpa"
+	hexDigits       = "0123456789abcdef"
+)
+
+// ReverseName returns the name queried by a PTR question on the address, see rfc1035 section 3.5 and rfc3596 section 2.5
+func ReverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return strconv.Itoa(int(v4[3])) + "." + strconv.Itoa(int(v4[2])) + "." +
+			strconv.Itoa(int(v4[1])) + "." + strconv.Itoa(int(v4[0])) + reverseV4Suffix
+	}
+	v6 := ip.To16()
+	if v6 == nil {
+		return ""
+	}
+	name := make([]byte, 0, 4*net.IPv6len+len(reverseV6Suffix))
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		name = append(name, hexDigits[v6[i]&0x0f], '.', hexDigits[v6[i]>>4], '.')
+	}
+	return string(name[:len(name)-1]) + reverseV6Suffix
+}
+
+// ParseReverseName returns the address of a reverse name, false if the name is not the reverse name of a whole address
+func ParseReverseName(name string) (net.IP, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if labels, ok := strings.CutSuffix(name, reverseV4Suffix); ok {
+		return parseReverseV4(strings.Split(labels, "."))
+	}
+	if labels, ok := strings.CutSuffix(name, reverseV6Suffix); ok {
+		return parseReverseV6(strings.Split(labels, "."))
+	}
+	return nil, false
+}
+
+func parseReverseV4(labels []string) (net.IP, bool) {
+	if len(labels) != net.IPv4len {
+		return nil, false
+	}
+	ip := make(net.IP, net.IPv4len)
+	for i, label := range labels {
+		if len(label) > 1 && label[0] == '0' {
+			return nil, false // leading zeros would give several names to the same address
+		}
+		v, err := strconv.ParseUint(label, 10, 8)
+		if err != nil {
+			return nil, false
+		}
+		ip[net.IPv4len-1-i] = byte(v)
+	}
+	return ip, true
+}
+
+func parseReverseV6(labels []string) (net.IP, bool) {
+	if len(labels) != 2*net.IPv6len {
+		return nil, false
+	}
+	ip := make(net.IP, net.IPv6len)
+	for i, label := range labels {
+		if len(label) != 1 {
+			return nil, false
+		}
+		nibble := strings.IndexByte(hexDigits, label[0])
+		if nibble < 0 {
+			return nil, false
+		}
+		if i%2 == 0 {
+			ip[net.IPv6len-1-i/2] |= byte(nibble)
+		} else {
+			ip[net.IPv6len-1-i/2] |= byte(nibble) << 4
+		}
+	}
+	return ip, true
+}
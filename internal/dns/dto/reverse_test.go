@@ -0,0 +1,46 @@
+package dto_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		name string
+	}{
+		{ip: "192.0.2.1", name: "1.2.0.192.in-addr.arpa"},
+		{ip: "2001:db8::567:89ab", name: "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			if got := dto.ReverseName(ip); got != tt.name {
+				t.Fatalf("ReverseName() = %s, want %s", got, tt.name)
+			}
+			got, ok := dto.ParseReverseName(tt.name + ".")
+			if !ok || !got.Equal(ip) {
+				t.Fatalf("ParseReverseName() = %v %v, want %v", got, ok, ip)
+			}
+		})
+	}
+}
+
+func TestParseReverseNameInvalid(t *testing.T) {
+	for _, name := range []string{
+		"example.com",
+		"2.0.192.in-addr.arpa",
+		"1.2.0.256.in-addr.arpa",
+		"01.2.0.192.in-addr.arpa",
+		"b.a.9.8.ip6.arpa",
+		"ba.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+		"g.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+	} {
+		if ip, ok := dto.ParseReverseName(name); ok {
+			t.Errorf("ParseReverseName(%s) = %v, want no address", name, ip)
+		}
+	}
+}
@@ -1,78 +1,229 @@
 package dto
 
 import (
-	"bytes"
 	"encoding/binary"
-	"net"
 	"strings"
 )
 
+// maxCompressedNames number of names remembered for the compression of a message
+const maxCompressedNames = 64
+
 //SerializeMessage serialize a DNS message into a binary representation
 func SerializeMessage(message Message) []byte {
-	var buffer bytes.Buffer
-
-	writeUint16(message.ID, &buffer)
-	writeUint16(message.Header, &buffer)
-	writeUint16(message.QuestionCount, &buffer)
-	writeUint16(message.ResponseCount, &buffer)
-	writeUint32(0, &buffer) // additionals rrs and authority rrs
-	for _, question := range message.Question {
-		writeQuestion(question, &buffer)
+	return AppendMessage(make([]byte, 0, ClassicUDPLength), &message)
+}
+
+// AppendMessage append the binary representation of the message to the buffer and returns the extended buffer.
+// Nothing is allocated when the buffer has enough capacity
+func AppendMessage(buffer []byte, message *Message) []byte {
+	names := compressor{start: len(buffer)}
+
+	buffer = binary.BigEndian.AppendUint16(buffer, message.ID)
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(message.Header))
+	buffer = binary.BigEndian.AppendUint16(buffer, message.QuestionCount)
+	buffer = binary.BigEndian.AppendUint16(buffer, message.ResponseCount)
+	buffer = binary.BigEndian.AppendUint16(buffer, message.AuthorityCount)
+	buffer = binary.BigEndian.AppendUint16(buffer, message.AdditionalCount)
+	for i := range message.Question {
+		buffer = writeQuestion(buffer, &message.Question[i], &names)
+	}
+
+	for i := range message.Response {
+		buffer = writeResponse(buffer, &message.Response[i], &names)
+	}
+
+	for i := range message.Authority {
+		buffer = writeResponse(buffer, &message.Authority[i], &names)
 	}
 
-	for _, response := range message.Response {
-		writeResponse(response, &buffer)
+	for i := range message.Additional {
+		buffer = writeResponse(buffer, &message.Additional[i], &names)
 	}
 
-	return buffer.Bytes()
+	return buffer
 }
 
-func writeQuestion(question Question, buffer *bytes.Buffer) {
-	writeName(question.Name, buffer)
-	writeUint16(uint16(question.Type), buffer)
-	writeUint16(uint16(question.Class), buffer)
+// SerializeTruncated serialize a DNS message, dropping records until it fits in maxSize bytes.
+// Additional records are dropped first, then authority and answer records, in which case the TC bit is set.
+// The OPT record is always kept.
+func SerializeTruncated(message Message, maxSize int) []byte {
+	return AppendTruncated(make([]byte, 0, maxSize), message, maxSize)
 }
 
-func writeResponse(response Record, buffer *bytes.Buffer) {
-	writeName(response.Name, buffer)
-	writeUint16(uint16(response.Type), buffer)
-	writeUint16(uint16(response.Class), buffer)
-	writeUint32(response.TTL, buffer)
-	writeData(response.Type, response.Data, buffer)
+// AppendTruncated append the binary representation of the message to the buffer, truncated like SerializeTruncated
+func AppendTruncated(buffer []byte, message Message, maxSize int) []byte {
+	start := len(buffer)
+	payload := AppendMessage(buffer, &message)
+	if len(payload)-start <= maxSize {
+		return payload
+	}
+
+	additional := make([]Record, 0, 1)
+	for _, record := range message.Additional {
+		if record.Type == OPT {
+			additional = append(additional, record)
+		}
+	}
+	message.Additional = additional
+	message.AdditionalCount = uint16(len(additional))
+
+	for payload = AppendMessage(payload[:start], &message); len(payload)-start > maxSize; payload = AppendMessage(payload[:start], &message) {
+		switch {
+		case len(message.Authority) > 0:
+			message.Authority = message.Authority[:len(message.Authority)-1]
+			message.AuthorityCount = uint16(len(message.Authority))
+		case len(message.Response) > 0:
+			message.Response = message.Response[:len(message.Response)-1]
+			message.ResponseCount = uint16(len(message.Response))
+		default:
+			return payload // nothing left to drop
+		}
+		message.Header.SetTC(true)
+	}
+	return payload
 }
 
-func writeName(s string, buffer *bytes.Buffer) {
-	nameParts := strings.Split(s, ".")
-	for _, p := range nameParts {
-		buffer.WriteByte(uint8(len(p)))
-		buffer.Write([]byte(p))
+func writeQuestion(buffer []byte, question *Question, names *compressor) []byte {
+	buffer = writeName(buffer, question.Name, names)
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(question.Type))
+	return binary.BigEndian.AppendUint16(buffer, uint16(question.Class))
+}
+
+func writeResponse(buffer []byte, response *Record, names *compressor) []byte {
+	buffer = writeName(buffer, response.Name, names)
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(response.Type))
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(response.Class))
+	buffer = binary.BigEndian.AppendUint32(buffer, response.TTL)
+	return writeData(buffer, response.Data, names)
+}
+
+// compressor remembers the offset of the names already written in the message, see rfc1035 section 4.1.4.
+// The names are not copied, they are compared with the content of the buffer
+type compressor struct {
+	start   int // offset of the message in the buffer
+	offsets [maxCompressedNames]uint16
+	count   int // number of offsets of the names fully written, the only ones found
+	pending int // number of offsets of the suffixes of the name being written
+}
+
+// find returns the offset of an already written name equal to s
+func (c *compressor) find(message []byte, s string) (int, bool) {
+	for _, offset := range c.offsets[:c.count] {
+		if nameEquals(message, int(offset), s) {
+			return int(offset), true
+		}
 	}
-	buffer.WriteByte(0)
+	return 0, false
 }
 
-func writeData(t Type, iP net.IP, buffer *bytes.Buffer) {
-	switch t {
-	case AAAA:
-		writeUint16(net.IPv6len, buffer)
-		break
-	case A:
-		writeUint16(net.IPv4len, buffer)
-		break
-	default:
-		writeUint16(net.IPv4len, buffer)
-		break
+// add remembers the offset of a suffix of the name being written, it is found once the name is committed
+func (c *compressor) add(offset int) {
+	if c.count+c.pending < maxCompressedNames && offset <= maxPointerOffset {
+		c.offsets[c.count+c.pending] = uint16(offset)
+		c.pending++
 	}
-	buffer.Write(iP)
 }
 
-func writeUint16(u uint16, buffer *bytes.Buffer) {
-	bytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(bytes, u)
-	buffer.Write(bytes)
+// commit makes the suffixes of the name written available for the compression of the next names.
+// A suffix is not available before, the compressed name would point to a name partially written
+func (c *compressor) commit() {
+	c.count += c.pending
+	c.pending = 0
 }
 
-func writeUint32(u uint32, buffer *bytes.Buffer) {
-	bytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(bytes, u)
-	buffer.Write(bytes)
+// nameEquals returns true if the name written at the offset of the message is s,
+// the names out of the message or with a pointer which is not backward are not equal
+func nameEquals(message []byte, offset int, s string) bool {
+	for offset < len(message) {
+		length := int(message[offset])
+		if byte(length)&labelTypeMask == labelTypeMask {
+			if offset+2 > len(message) {
+				return false
+			}
+			target := int(binary.BigEndian.Uint16(message[offset:]) & maxPointerOffset)
+			if target >= offset {
+				return false
+			}
+			offset = target
+			continue
+		}
+		if length == 0 {
+			return s == ""
+		}
+		if offset+1+length > len(message) {
+			return false
+		}
+		label, rest, _ := strings.Cut(s, ".")
+		if string(message[offset+1:offset+1+length]) != label {
+			return false
+		}
+		s = rest
+		offset += 1 + length
+	}
+	return false
+}
+
+// writeName write the name, replacing its longest already written suffix by a pointer.
+// A nil compressor writes the full name, as required for the names that must not be compressed
+func writeName(buffer []byte, s string, names *compressor) []byte {
+	for s != "" {
+		if names != nil {
+			if offset, ok := names.find(buffer[names.start:], s); ok {
+				names.commit()
+				return binary.BigEndian.AppendUint16(buffer, uint16(offset)|uint16(refStartByte)<<8)
+			}
+			names.add(len(buffer) - names.start)
+		}
+		label, rest, _ := strings.Cut(s, ".")
+		buffer = append(buffer, uint8(len(label)))
+		buffer = append(buffer, label...)
+		s = rest
+	}
+	if names != nil {
+		names.commit()
+	}
+	return append(buffer, 0)
+}
+
+// writeData write the data length followed by the type specific payload
+func writeData(buffer []byte, data RData, names *compressor) []byte {
+	lengthOffset := len(buffer)
+	buffer = append(buffer, 0, 0) // placeholder, the length is known once the payload is written
+	switch d := data.(type) {
+	case IPData:
+		buffer = append(buffer, d...)
+	case NameData:
+		buffer = writeName(buffer, string(d), names)
+	case MXData:
+		buffer = binary.BigEndian.AppendUint16(buffer, d.Preference)
+		buffer = writeName(buffer, d.Exchange, names)
+	case TXTData:
+		for _, s := range d {
+			buffer = append(buffer, uint8(len(s)))
+			buffer = append(buffer, s...)
+		}
+	case SOAData:
+		buffer = writeName(buffer, d.MName, names)
+		buffer = writeName(buffer, d.RName, names)
+		buffer = binary.BigEndian.AppendUint32(buffer, d.Serial)
+		buffer = binary.BigEndian.AppendUint32(buffer, d.Refresh)
+		buffer = binary.BigEndian.AppendUint32(buffer, d.Retry)
+		buffer = binary.BigEndian.AppendUint32(buffer, d.Expire)
+		buffer = binary.BigEndian.AppendUint32(buffer, d.Minimum)
+	case SRVData:
+		buffer = binary.BigEndian.AppendUint16(buffer, d.Priority)
+		buffer = binary.BigEndian.AppendUint16(buffer, d.Weight)
+		buffer = binary.BigEndian.AppendUint16(buffer, d.Port)
+		buffer = writeName(buffer, d.Target, nil) // rfc2782 forbids the compression of the target
+	case OPTData:
+		for _, o := range d {
+			buffer = binary.BigEndian.AppendUint16(buffer, o.Code)
+			buffer = binary.BigEndian.AppendUint16(buffer, uint16(len(o.Data)))
+			buffer = append(buffer, o.Data...)
+		}
+	case RawData:
+		buffer = append(buffer, d...)
+	}
+	binary.BigEndian.PutUint16(buffer[lengthOffset:], uint16(len(buffer)-lengthOffset-2))
+	return buffer
 }
@@ -1,6 +1,8 @@
 package resolver
 
 import (
+	"context"
+
 	"github.com/bluguard/dnshield/internal/dns/cache"
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
@@ -26,10 +28,10 @@ func (r *Cachefeeder) Name() string {
 }
 
 // Resolve implements Resolver
-func (r *Cachefeeder) Resolve(question dto.Question) (dto.Record, bool) {
-	result, ok := r.delegate.Resolve(question)
-	if ok {
-		r.cache.Feed(result)
+func (r *Cachefeeder) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, Status) {
+	result, status := r.delegate.Resolve(ctx, question)
+	if status == Found {
+		r.cache.Feed(question, result)
 	}
-	return result, ok
+	return result, status
 }
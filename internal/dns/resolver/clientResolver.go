@@ -1,6 +1,10 @@
 package resolver
 
 import (
+	"context"
+	"errors"
+	"log"
+
 	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
@@ -26,20 +30,38 @@ func (resolver *ClientResolver) Name() string {
 }
 
 // Resolve implements Resolver
-// Use the client to get the records
-func (resolver *ClientResolver) Resolve(question dto.Question) (dto.Record, bool) {
-	var callClient func(string) (dto.Record, error)
-	if question.Type == dto.A {
-		callClient = resolver.client.ResolveV4
-	} else if question.Type == dto.AAAA {
-		callClient = resolver.client.ResolveV6
-	}
-	if callClient == nil {
-		return dto.Record{}, false
+// Use the client to get the records, zone transfers and meta types are not supported.
+// The negative answers return the SOA of the zone sent by the client
+func (resolver *ClientResolver) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, Status) {
+	switch question.Type {
+	case dto.AXFR, dto.IXFR, dto.OPT:
+		return nil, NotImplemented
 	}
-	record, err := callClient(question.Name)
+	records, err := resolver.client.Resolve(ctx, question)
 	if err != nil {
-		return dto.Record{}, false
+		status := toStatus(err)
+		if status == Failure {
+			log.Println(resolver.name, "failed to resolve", question.Name+":", err)
+		}
+		var negative *client.NegativeAnswer
+		if errors.As(err, &negative) && (status == NameError || status == NoData) {
+			return negative.Authority, status
+		}
+		return nil, status
+	}
+	return records, Found
+}
+
+// toStatus convert an error returned by a client to the matching resolution status
+func toStatus(err error) Status {
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return NotFound
+	case errors.Is(err, client.ErrNameError):
+		return NameError
+	case errors.Is(err, client.ErrNoData):
+		return NoData
+	default:
+		return Failure
 	}
-	return record, true
 }
@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"context"
 	"errors"
 	"net"
 	"reflect"
@@ -12,30 +13,41 @@ import (
 
 var _ client.Client = MockClient{}
 
-type MockClient struct {
-	v4Count int
-	v6Count int
-}
+// negativeSOA is the authority of the negative answers of the MockClient
+var negativeSOA = dto.Record{Name: "example", Type: dto.SOA, Class: dto.IN, TTL: 300, Data: dto.SOAData{MName: "ns.example", RName: "noc.example", Serial: 1, Minimum: 300}}
+
+type MockClient struct{}
 
-// ResolveV4 implements client.Client
-func (m MockClient) ResolveV4(name string) (dto.Record, error) {
-	m.v4Count++
-	if name == "localhost" {
-		return dto.Record{
+// Resolve implements client.Client
+func (m MockClient) Resolve(_ context.Context, question dto.Question) ([]dto.Record, error) {
+	switch question.Name {
+	case "nxdomain":
+		return nil, client.ErrNameError
+	case "nodata":
+		return nil, client.ErrNoData
+	case "missing":
+		return nil, client.ErrNotFound
+	case "nxdomain.example":
+		return nil, client.Negative(client.ErrNameError, []dto.Record{negativeSOA})
+	case "nodata.example":
+		return nil, client.Negative(client.ErrNoData, []dto.Record{negativeSOA})
+	}
+	if question.Name != "localhost" {
+		return nil, errors.New("unknown")
+	}
+	switch question.Type {
+	case dto.A:
+		return []dto.Record{{
 			Name:  "localhost",
 			Type:  dto.A,
 			Class: dto.IN,
 			TTL:   200,
-			Data:  net.ParseIP("127.0.0.1").To4(),
-		}, nil
+			Data:  dto.IPData(net.ParseIP("127.0.0.1").To4()),
+		}}, nil
+	case dto.MX:
+		return []dto.Record{{Name: "localhost", Type: dto.MX, Class: dto.IN, TTL: 200, Data: dto.MXData{Preference: 10, Exchange: "localhost"}}}, nil
 	}
-	return dto.Record{}, errors.New("unknown")
-}
-
-// ResolveV6 implements client.Client
-func (m MockClient) ResolveV6(name string) (dto.Record, error) {
-	m.v6Count++
-	return dto.Record{}, errors.New("unsuported")
+	return nil, errors.New("unsuported")
 }
 
 func TestClientResolver_Resolve(t *testing.T) {
@@ -48,8 +60,8 @@ func TestClientResolver_Resolve(t *testing.T) {
 	tests := []struct {
 		name     string
 		question dto.Question
-		want     dto.Record
-		ok       bool
+		want     []dto.Record
+		status   Status
 	}{
 		{
 			name: "localhost v4",
@@ -58,14 +70,14 @@ func TestClientResolver_Resolve(t *testing.T) {
 				Type:  dto.A,
 				Class: dto.IN,
 			},
-			want: dto.Record{
+			want: []dto.Record{{
 				Name:  "localhost",
 				Type:  dto.A,
 				Class: dto.IN,
 				TTL:   200,
-				Data:  net.ParseIP("127.0.0.1").To4(),
-			},
-			ok: true,
+				Data:  dto.IPData(net.ParseIP("127.0.0.1").To4()),
+			}},
+			status: Found,
 		},
 		{
 			name: "localhost v6",
@@ -74,29 +86,65 @@ func TestClientResolver_Resolve(t *testing.T) {
 				Type:  dto.AAAA,
 				Class: dto.IN,
 			},
-			want: dto.Record{},
-			ok:   false,
+			want:   nil,
+			status: Failure,
 		},
 		{
 			name: "localhost unknown",
 			question: dto.Question{
 				Name:  "localhost",
-				Type:  dto.Type(50),
+				Type:  dto.AXFR,
 				Class: dto.IN,
 			},
-			want: dto.Record{},
-			ok:   false,
+			want:   nil,
+			status: NotImplemented,
+		},
+		{
+			name:     "localhost mx",
+			question: dto.Question{Name: "localhost", Type: dto.MX, Class: dto.IN},
+			want:     []dto.Record{{Name: "localhost", Type: dto.MX, Class: dto.IN, TTL: 200, Data: dto.MXData{Preference: 10, Exchange: "localhost"}}},
+			status:   Found,
+		},
+		{
+			name:     "nxdomain v4",
+			question: dto.Question{Name: "nxdomain", Type: dto.A, Class: dto.IN},
+			want:     nil,
+			status:   NameError,
+		},
+		{
+			name:     "nodata v4",
+			question: dto.Question{Name: "nodata", Type: dto.A, Class: dto.IN},
+			want:     nil,
+			status:   NoData,
+		},
+		{
+			name:     "nxdomain with the soa",
+			question: dto.Question{Name: "nxdomain.example", Type: dto.A, Class: dto.IN},
+			want:     []dto.Record{negativeSOA},
+			status:   NameError,
+		},
+		{
+			name:     "nodata with the soa",
+			question: dto.Question{Name: "nodata.example", Type: dto.A, Class: dto.IN},
+			want:     []dto.Record{negativeSOA},
+			status:   NoData,
+		},
+		{
+			name:     "missing v4",
+			question: dto.Question{Name: "missing", Type: dto.A, Class: dto.IN},
+			want:     nil,
+			status:   NotFound,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got, ok := resolver.Resolve(tt.question)
+			got, status := resolver.Resolve(context.Background(), tt.question)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("ClientResolver.Resolve() got = %v, want %v", got, tt.want)
 			}
-			if ok != tt.ok {
-				t.Errorf("ClientResolver.Resolve() ok = %v, want %v", ok, tt.ok)
+			if status != tt.status {
+				t.Errorf("ClientResolver.Resolve() status = %v, want %v", status, tt.status)
 			}
 		})
 	}
@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ Resolver = &CoalescingResolver{}
+
+// CoalescingResolver shares the resolution of identical questions asked at the same time,
+// the delegate is asked once and every pending query gets its answer
+type CoalescingResolver struct {
+	delegate Resolver
+	lock     sync.Mutex
+	pending  map[dto.Question]*resolution
+}
+
+// resolution is a question being resolved by the delegate, done is closed once the answer is set.
+// expired tells that the resolution reached the deadline of the query which started it
+type resolution struct {
+	done    chan struct{}
+	records []dto.Record
+	status  Status
+	expired bool
+}
+
+// NewCoalescingResolver instantiate a CoalescingResolver asking the delegate
+func NewCoalescingResolver(delegate Resolver) *CoalescingResolver {
+	return &CoalescingResolver{
+		delegate: delegate,
+		pending:  make(map[dto.Question]*resolution),
+	}
+}
+
+// Name implements Resolver
+func (r *CoalescingResolver) Name() string {
+	return r.delegate.Name()
+}
+
+// Resolve implements Resolver
+func (r *CoalescingResolver) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, Status) {
+	key := dto.Question{Name: normalize(question.Name), Type: question.Type, Class: question.Class}
+	records, status, expired := r.wait(ctx, r.join(ctx, key, question))
+	if expired && ctx.Err() == nil {
+		// the shared resolution had the deadline of another query, this one still has time to ask again
+		records, status, _ = r.wait(ctx, r.join(ctx, key, question))
+	}
+	return records, status
+}
+
+// join returns the pending resolution of the question, a new one is started when there is none
+func (r *CoalescingResolver) join(ctx context.Context, key dto.Question, question dto.Question) *resolution {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	res, ok := r.pending[key]
+	if !ok {
+		res = &resolution{done: make(chan struct{})}
+		r.pending[key] = res
+		go r.resolve(ctx, key, question, res)
+	}
+	return res
+}
+
+// wait returns the answer of the resolution, or a failure once the context is done
+func (r *CoalescingResolver) wait(ctx context.Context, res *resolution) ([]dto.Record, Status, bool) {
+	select {
+	case <-res.done:
+		// the records are shared by the queries, each one gets its own slice
+		return slices.Clone(res.records), res.status, res.expired
+	case <-ctx.Done():
+		return nil, Failure, false
+	}
+}
+
+// resolve asks the delegate and shares its answer with the pending queries.
+// The resolution outlives the query which started it, it is bounded by its deadline but not cancelled with it
+// so that a client giving up does not fail the other queries
+func (r *CoalescingResolver) resolve(ctx context.Context, key dto.Question, question dto.Question, res *resolution) {
+	shared := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		shared, cancel = context.WithDeadline(shared, deadline)
+		defer cancel()
+	}
+	res.records, res.status = r.delegate.Resolve(shared, question)
+	res.expired = shared.Err() != nil
+
+	r.lock.Lock()
+	delete(r.pending, key)
+	r.lock.Unlock()
+	close(res.done)
+}
@@ -0,0 +1,161 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ Resolver = &blockingResolver{}
+
+// blockingResolver answers the questions once released, counting the questions it is asked
+type blockingResolver struct {
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+// Name implements Resolver
+func (r *blockingResolver) Name() string {
+	return "blocking"
+}
+
+// Resolve implements Resolver
+func (r *blockingResolver) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, Status) {
+	r.calls.Add(1)
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return nil, Failure
+	}
+	return []dto.Record{{Name: question.Name, Type: question.Type, Class: dto.IN, TTL: 60, Data: dto.IPData{127, 0, 0, 1}}}, Found
+}
+
+func TestCoalescingResolver_Resolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		questions []dto.Question
+		wantCalls int32
+	}{
+		{name: "identical", wantCalls: 1, questions: []dto.Question{
+			{Name: "example.com", Type: dto.A, Class: dto.IN},
+			{Name: "example.com", Type: dto.A, Class: dto.IN},
+			{Name: "example.com", Type: dto.A, Class: dto.IN},
+		}},
+		{name: "case and trailing dot", wantCalls: 1, questions: []dto.Question{
+			{Name: "example.com", Type: dto.A, Class: dto.IN},
+			{Name: "Example.COM.", Type: dto.A, Class: dto.IN},
+		}},
+		{name: "different types", wantCalls: 2, questions: []dto.Question{
+			{Name: "example.com", Type: dto.A, Class: dto.IN},
+			{Name: "example.com", Type: dto.AAAA, Class: dto.IN},
+			{Name: "example.com", Type: dto.AAAA, Class: dto.IN},
+		}},
+		{name: "different names", wantCalls: 2, questions: []dto.Question{
+			{Name: "example.com", Type: dto.A, Class: dto.IN},
+			{Name: "example.org", Type: dto.A, Class: dto.IN},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delegate := &blockingResolver{release: make(chan struct{})}
+			resolver := NewCoalescingResolver(delegate)
+			wg := sync.WaitGroup{}
+			for _, question := range tt.questions {
+				wg.Add(1)
+				go func(question dto.Question) {
+					defer wg.Done()
+					records, status := resolver.Resolve(context.Background(), question)
+					if status != Found || len(records) != 1 {
+						t.Errorf("CoalescingResolver.Resolve() = %v, %v", records, status)
+					}
+				}(question)
+			}
+			for delegate.calls.Load() < tt.wantCalls {
+				time.Sleep(time.Millisecond)
+			}
+			// let the other queries join the pending resolutions
+			time.Sleep(10 * time.Millisecond)
+			close(delegate.release)
+			wg.Wait()
+			if n := delegate.calls.Load(); n != tt.wantCalls {
+				t.Errorf("expecting %d resolutions, got %d", tt.wantCalls, n)
+			}
+		})
+	}
+}
+
+func TestCoalescingResolver_Cancel(t *testing.T) {
+	delegate := &blockingResolver{release: make(chan struct{})}
+	resolver := NewCoalescingResolver(delegate)
+	question := dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}
+
+	// the query starting the resolution gives up, the other query still gets the answer
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan Status, 1)
+	go func() {
+		_, status := resolver.Resolve(ctx, question)
+		first <- status
+	}()
+	for delegate.calls.Load() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan Status, 1)
+	go func() {
+		_, status := resolver.Resolve(context.Background(), question)
+		second <- status
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if status := <-first; status != Failure {
+		t.Errorf("CoalescingResolver.Resolve() cancelled status = %v, want %v", status, Failure)
+	}
+	close(delegate.release)
+	if status := <-second; status != Found {
+		t.Errorf("CoalescingResolver.Resolve() status = %v, want %v", status, Found)
+	}
+
+	// the resolution is over, the next query asks the delegate again
+	if _, status := resolver.Resolve(context.Background(), question); status != Found {
+		t.Errorf("CoalescingResolver.Resolve() status = %v, want %v", status, Found)
+	}
+	if n := delegate.calls.Load(); n != 2 {
+		t.Errorf("expecting 2 resolutions, got %d", n)
+	}
+}
+
+func TestCoalescingResolver_Deadline(t *testing.T) {
+	delegate := &blockingResolver{release: make(chan struct{})}
+	resolver := NewCoalescingResolver(delegate)
+	question := dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}
+
+	// the query starting the resolution has a short deadline, the query joining it still gets the answer
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	first := make(chan Status, 1)
+	go func() {
+		_, status := resolver.Resolve(ctx, question)
+		first <- status
+	}()
+	for delegate.calls.Load() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan Status, 1)
+	go func() {
+		_, status := resolver.Resolve(context.Background(), question)
+		second <- status
+	}()
+	if status := <-first; status != Failure {
+		t.Errorf("CoalescingResolver.Resolve() expired status = %v, want %v", status, Failure)
+	}
+	for delegate.calls.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(delegate.release)
+	if status := <-second; status != Found {
+		t.Errorf("CoalescingResolver.Resolve() status = %v, want %v", status, Found)
+	}
+}
@@ -0,0 +1,68 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ Resolver = &ForwardResolver{}
+
+// ForwardResolver forwards the questions to the resolver of the longest zone containing the queried name,
+// the questions outside of every zone or not found in their zone are asked to the fallback.
+// The failures of a zone are not sent to the fallback, the names of a private zone must not leak to the public dns
+type ForwardResolver struct {
+	name     string
+	zones    map[string]Resolver
+	fallback Resolver
+}
+
+// NewForwardResolver instantiate a ForwardResolver, zones maps the domain suffixes to their resolver, fallback may be nil
+func NewForwardResolver(name string, zones map[string]Resolver, fallback Resolver) *ForwardResolver {
+	res := &ForwardResolver{
+		name:     name,
+		zones:    make(map[string]Resolver, len(zones)),
+		fallback: fallback,
+	}
+	for zone, resolver := range zones {
+		res.zones[normalize(zone)] = resolver
+	}
+	return res
+}
+
+// Name implements Resolver
+func (r *ForwardResolver) Name() string {
+	return r.name
+}
+
+// Resolve implements Resolver
+func (r *ForwardResolver) Resolve(ctx context.Context, question dto.Question) ([]dto.Record, Status) {
+	if resolver, ok := r.lookup(question.Name); ok {
+		records, status := resolver.Resolve(ctx, question)
+		if status != NotFound {
+			return records, status
+		}
+	}
+	if r.fallback == nil {
+		return nil, NotFound
+	}
+	return r.fallback.Resolve(ctx, question)
+}
+
+// lookup returns the resolver of the longest zone containing the name, the labels are removed one by one from the left
+func (r *ForwardResolver) lookup(name string) (Resolver, bool) {
+	name = normalize(name)
+	for name != "" {
+		if resolver, ok := r.zones[name]; ok {
+			return resolver, true
+		}
+		_, name, _ = strings.Cut(name, ".")
+	}
+	return nil, false
+}
+
+// normalize returns the name in lower case without its trailing dot, names are case insensitive see rfc4343
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
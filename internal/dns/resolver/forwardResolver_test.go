@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ Resolver = namedResolver("")
+
+// namedResolver answers every question with a TXT record containing its name
+type namedResolver string
+
+// Name implements Resolver
+func (r namedResolver) Name() string {
+	return string(r)
+}
+
+// Resolve implements Resolver
+func (r namedResolver) Resolve(_ context.Context, question dto.Question) ([]dto.Record, Status) {
+	return []dto.Record{{Name: question.Name, Type: dto.TXT, Class: dto.IN, TTL: 60, Data: dto.TXTData{string(r)}}}, Found
+}
+
+func TestForwardResolver_Resolve(t *testing.T) {
+	resolver := NewForwardResolver("Forward", map[string]Resolver{
+		"corp.example.":        namedResolver("corp"),
+		"lab.corp.example":     namedResolver("lab"),
+		"168.192.in-addr.arpa": namedResolver("lan"),
+		"down.example":         statusResolver(Failure),
+		"empty.example":        statusResolver(NotFound),
+	}, namedResolver("fallback"))
+
+	tests := []struct {
+		name       string
+		question   string
+		want       string
+		wantStatus Status
+	}{
+		{name: "zone apex", question: "corp.example", want: "corp", wantStatus: Found},
+		{name: "sub domain", question: "www.corp.example", want: "corp", wantStatus: Found},
+		{name: "longest suffix", question: "host.lab.corp.example", want: "lab", wantStatus: Found},
+		{name: "case and trailing dot", question: "Host.LAB.corp.example.", want: "lab", wantStatus: Found},
+		{name: "reverse zone", question: "1.0.168.192.in-addr.arpa", want: "lan", wantStatus: Found},
+		{name: "label boundary", question: "notcorp.example", want: "fallback", wantStatus: Found},
+		{name: "outside", question: "example.com", want: "fallback", wantStatus: Found},
+		{name: "parent", question: "example", want: "fallback", wantStatus: Found},
+		{name: "zone failure", question: "www.down.example", wantStatus: Failure},
+		{name: "not found in zone", question: "www.empty.example", want: "fallback", wantStatus: Found},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, status := resolver.Resolve(context.Background(), dto.Question{Name: tt.question, Type: dto.TXT, Class: dto.IN})
+			if status != tt.wantStatus {
+				t.Fatalf("ForwardResolver.Resolve() status = %v, want %v", status, tt.wantStatus)
+			}
+			if tt.wantStatus != Found {
+				return
+			}
+			if want := (dto.TXTData{tt.want}); !reflect.DeepEqual(got[0].Data, want) {
+				t.Errorf("ForwardResolver.Resolve() = %v, want %v", got[0].Data, want)
+			}
+		})
+	}
+}
+
+func TestForwardResolver_NoFallback(t *testing.T) {
+	resolver := NewForwardResolver("Forward", map[string]Resolver{"corp.example": namedResolver("corp")}, nil)
+	if _, status := resolver.Resolve(context.Background(), dto.Question{Name: "example.com", Type: dto.A, Class: dto.IN}); status != NotFound {
+		t.Fatalf("ForwardResolver.Resolve() status = %v, want %v", status, NotFound)
+	}
+}
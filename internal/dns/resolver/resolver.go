@@ -1,15 +1,36 @@
 package resolver
 
 import (
-	"errors"
+	"context"
 	"log"
 	"strconv"
 
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
 
+// Status is the outcome of a resolution
+type Status int
+
+const (
+	// NotFound the resolver has no answer, the next resolver of the chain is asked
+	NotFound Status = iota
+	// Found the resolver has answered the question
+	Found
+	// NameError the queried name does not exist
+	NameError
+	// NoData the queried name exists but has no record of the queried type
+	NoData
+	// Failure the resolver failed to get an answer
+	Failure
+	// NotImplemented the question is not supported by the resolver
+	NotImplemented
+)
+
+// Resolver answers a question with its whole answer set.
+// The negative answers, NameError and NoData, return the authority records of the answer instead, the SOA of the zone
+// which tells how long the answer can be cached, see rfc2308
 type Resolver interface {
-	Resolve(dto.Question) (dto.Record, bool)
+	Resolve(context.Context, dto.Question) ([]dto.Record, Status)
 	Name() string
 }
 
@@ -24,38 +45,108 @@ type ResolverChain struct {
 	chain []Resolver
 }
 
-func (resolverChain *ResolverChain) Resolve(message dto.Message) dto.Message {
-	records := resolverChain.resolveAll(message.Question)
+// Resolve answers the questions of the message, the resolution is abandoned with a SERVFAIL once the context is done
+func (resolverChain *ResolverChain) Resolve(ctx context.Context, message dto.Message) dto.Message {
 	response := dto.Message{
 		ID:            message.ID,
-		Header:        dto.STANDARD_RESPONSE,
+		Header:        responseHeader(message.Header),
 		QuestionCount: message.QuestionCount,
-		ResponseCount: uint16(len(records)),
 		Question:      message.Question,
-		Response:      records,
+		Response:      []dto.Record{},
+	}
+	if message.Header.Opcode() != dto.QUERY {
+		response.Header.SetRCode(dto.NOTIMP)
+		return response
+	}
+	if len(message.Question) == 0 {
+		// a query without question is malformed, there is nothing to answer
+		response.Header.SetRCode(dto.FORMERR)
+		return response
 	}
 
+	records, authority, rcode := resolverChain.resolveAll(ctx, message.Question)
+	response.ResponseCount = uint16(len(records))
+	response.Response = records
+	response.AuthorityCount = uint16(len(authority))
+	response.Authority = authority
+	response.Header.SetRCode(rcode)
 	return response
 }
 
-func (resolverChain *ResolverChain) resolveAll(questions []dto.Question) []dto.Record {
+// responseHeader build the header of the response to a query with the given header
+func responseHeader(query dto.Header) dto.Header {
+	var header dto.Header
+	header.SetQR(true)
+	header.SetOpcode(query.Opcode())
+	header.SetRD(query.RD())
+	header.SetRA(true)
+	header.SetCD(query.CD())
+	return header
+}
+
+// resolveAll resolve every question, the response code is the one of the first unsuccessful question
+// and the authority records are the ones of its negative answer
+func (resolverChain *ResolverChain) resolveAll(ctx context.Context, questions []dto.Question) ([]dto.Record, []dto.Record, dto.RCode) {
 	records := make([]dto.Record, 0, 4)
+	var authority []dto.Record
+	rcode := dto.NOERROR
 	for _, question := range questions {
-		r, err := resolverChain.resolveOne(question)
-		if err != nil {
-			log.Println(err.Error())
-		} else {
-			records = append(records, r)
+		r, status := resolverChain.resolveOne(ctx, question)
+		if status == Found {
+			records = append(records, r...)
+			continue
+		}
+		if rcode == dto.NOERROR {
+			rcode = toRCode(status)
+			authority = nil
+			if status == NameError || status == NoData {
+				authority = r
+			}
+		}
+		if status == NotFound {
+			log.Println("no record found for " + question.Name + " with type " + strconv.Itoa(int(question.Type)))
 		}
 	}
-	return records
+	return records, authority, rcode
 }
 
-func (resolverChain *ResolverChain) resolveOne(question dto.Question) (dto.Record, error) {
+// resolveOne ask the resolvers in order until one of them knows the answer.
+// Failures are remembered so the chain reports them if no resolver knows the answer
+func (resolverChain *ResolverChain) resolveOne(ctx context.Context, question dto.Question) ([]dto.Record, Status) {
+	result := NotFound
 	for _, resolver := range resolverChain.chain {
-		if record, ok := resolver.Resolve(question); ok {
-			return record, nil
+		if ctx.Err() != nil {
+			log.Println("abandon resolution of " + question.Name + ": " + ctx.Err().Error())
+			return nil, Failure
+		}
+		records, status := resolver.Resolve(ctx, question)
+		switch status {
+		case Found, NameError, NoData:
+			return records, status
+		case Failure:
+			result = Failure
+		case NotImplemented:
+			if result == NotFound {
+				result = NotImplemented
+			}
 		}
 	}
-	return dto.Record{}, errors.New("no record found for " + question.Name + " with class " + strconv.Itoa(int(question.Type)))
+	return nil, result
+}
+
+// toRCode returns the response code to send for an unsuccessful status
+func toRCode(status Status) dto.RCode {
+	switch status {
+	case NameError:
+		return dto.NXDOMAIN
+	case NoData:
+		return dto.NOERROR
+	case NotImplemented:
+		return dto.NOTIMP
+	case NotFound:
+		// no resolver serves the name, like an external name when the external resolution is not allowed
+		return dto.REFUSED
+	default:
+		return dto.SERVFAIL
+	}
 }
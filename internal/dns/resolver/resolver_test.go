@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"context"
 	"net"
 	"reflect"
 	"testing"
@@ -19,7 +20,7 @@ func (resolverMock) Name() string {
 }
 
 // Resolve implements Resolver
-func (resolverMock) Resolve(question dto.Question) (dto.Record, bool) {
+func (resolverMock) Resolve(_ context.Context, question dto.Question) ([]dto.Record, Status) {
 	record := dto.Record{
 		Name:  question.Name,
 		Type:  question.Type,
@@ -27,13 +28,13 @@ func (resolverMock) Resolve(question dto.Question) (dto.Record, bool) {
 		TTL:   12000,
 	}
 	if question.Type == dto.A {
-		record.Data = net.ParseIP("127.0.0.1").To4()
-		return record, true
+		record.Data = dto.IPData(net.ParseIP("127.0.0.1").To4())
+		return []dto.Record{record}, Found
 	} else if question.Type == dto.AAAA {
-		record.Data = net.ParseIP("::1:").To16()
-		return record, true
+		record.Data = dto.IPData(net.ParseIP("::1:").To16())
+		return []dto.Record{record}, Found
 	}
-	return dto.Record{}, false
+	return nil, NotImplemented
 }
 
 func TestResolverChain_Resolve(t *testing.T) {
@@ -79,7 +80,7 @@ func TestResolverChain_Resolve(t *testing.T) {
 						Type:  dto.A,
 						Class: dto.IN,
 						TTL:   12000,
-						Data:  net.ParseIP("127.0.0.1").To4(),
+						Data:  dto.IPData(net.ParseIP("127.0.0.1").To4()),
 					},
 				},
 			},
@@ -118,7 +119,46 @@ func TestResolverChain_Resolve(t *testing.T) {
 						Type:  dto.AAAA,
 						Class: dto.IN,
 						TTL:   12000,
-						Data:  net.ParseIP("::1:").To16(),
+						Data:  dto.IPData(net.ParseIP("::1:").To16()),
+					},
+				},
+			},
+		},
+		{
+			name: "localhost A checking disabled without recursion",
+			message: dto.Message{
+				ID:            4,
+				Header:        0x0010,
+				QuestionCount: 1,
+				ResponseCount: 0,
+				Question: []dto.Question{
+					{
+						Name:  "localhost",
+						Type:  dto.A,
+						Class: dto.IN,
+					},
+				},
+				Response: []dto.Record{},
+			},
+			want: dto.Message{
+				ID:            4,
+				Header:        0x8090,
+				QuestionCount: 1,
+				ResponseCount: 1,
+				Question: []dto.Question{
+					{
+						Name:  "localhost",
+						Type:  dto.A,
+						Class: dto.IN,
+					},
+				},
+				Response: []dto.Record{
+					{
+						Name:  "localhost",
+						Type:  dto.A,
+						Class: dto.IN,
+						TTL:   12000,
+						Data:  dto.IPData(net.ParseIP("127.0.0.1").To4()),
 					},
 				},
 			},
@@ -141,7 +181,7 @@ func TestResolverChain_Resolve(t *testing.T) {
 			},
 			want: dto.Message{
 				ID:            3,
-				Header:        dto.STANDARD_RESPONSE,
+				Header:        0x8184,
 				QuestionCount: 1,
 				ResponseCount: 0,
 				Question: []dto.Question{
@@ -158,9 +198,114 @@ func TestResolverChain_Resolve(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			if got := resolverChain.Resolve(tt.message); !reflect.DeepEqual(got, tt.want) {
+			if got := resolverChain.Resolve(context.Background(), tt.message); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("ResolverChain.Resolve() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+var _ Resolver = statusResolver(NotFound)
+
+// statusResolver always resolve with the same status
+type statusResolver Status
+
+// Name implements Resolver
+func (statusResolver) Name() string {
+	return "status"
+}
+
+// Resolve implements Resolver
+func (r statusResolver) Resolve(_ context.Context, question dto.Question) ([]dto.Record, Status) {
+	if Status(r) != Found {
+		return nil, Status(r)
+	}
+	return []dto.Record{{Name: question.Name, Type: question.Type, Class: question.Class, TTL: 60, Data: dto.IPData{127, 0, 0, 1}}}, Found
+}
+
+func TestResolverChain_RCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		chain   []Resolver
+		header  dto.Header
+		rcode   dto.RCode
+		answers int
+	}{
+		{name: "found after a failure", chain: []Resolver{statusResolver(Failure), statusResolver(Found)}, header: dto.STANDARD_QUERY, rcode: dto.NOERROR, answers: 1},
+		{name: "name error", chain: []Resolver{statusResolver(NotFound), statusResolver(NameError)}, header: dto.STANDARD_QUERY, rcode: dto.NXDOMAIN},
+		{name: "no data", chain: []Resolver{statusResolver(NoData), statusResolver(Found)}, header: dto.STANDARD_QUERY, rcode: dto.NOERROR},
+		{name: "failure", chain: []Resolver{statusResolver(Failure), statusResolver(NotImplemented)}, header: dto.STANDARD_QUERY, rcode: dto.SERVFAIL},
+		{name: "nobody knows", chain: []Resolver{statusResolver(NotFound)}, header: dto.STANDARD_QUERY, rcode: dto.REFUSED},
+		{name: "not implemented", chain: []Resolver{statusResolver(NotImplemented), statusResolver(NotFound)}, header: dto.STANDARD_QUERY, rcode: dto.NOTIMP},
+		{name: "unsupported opcode", chain: []Resolver{statusResolver(Found)}, header: 0x2100, rcode: dto.NOTIMP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewResolverChain(tt.chain).Resolve(context.Background(), dto.Message{
+				ID:            1,
+				Header:        tt.header,
+				QuestionCount: 1,
+				Question:      []dto.Question{{Name: "localhost", Type: dto.A, Class: dto.IN}},
+			})
+			if got.Header.RCode() != tt.rcode {
+				t.Errorf("ResolverChain.Resolve() rcode = %v, want %v", got.Header.RCode(), tt.rcode)
+			}
+			if !got.Header.QR() || got.Header.Opcode() != tt.header.Opcode() {
+				t.Errorf("ResolverChain.Resolve() unexpected header %x", uint16(got.Header))
+			}
+			if int(got.ResponseCount) != tt.answers || len(got.Response) != tt.answers {
+				t.Errorf("ResolverChain.Resolve() got %d answers, want %d", got.ResponseCount, tt.answers)
+			}
+		})
+	}
+}
+
+func TestResolverChain_NoQuestion(t *testing.T) {
+	got := NewResolverChain([]Resolver{statusResolver(Found)}).Resolve(context.Background(), dto.Message{ID: 1, Header: dto.STANDARD_QUERY})
+	if got.Header.RCode() != dto.FORMERR || got.ResponseCount != 0 {
+		t.Errorf("ResolverChain.Resolve() rcode = %v with %d answers, want %v", got.Header.RCode(), got.ResponseCount, dto.FORMERR)
+	}
+}
+
+func TestResolverChain_NegativeAuthority(t *testing.T) {
+	chain := NewResolverChain([]Resolver{NewClientresolver(MockClient{}, "mock")})
+	tests := []struct {
+		name      string
+		questions []dto.Question
+		rcode     dto.RCode
+		authority []dto.Record
+	}{
+		{name: "name error", questions: []dto.Question{{Name: "nxdomain.example", Type: dto.A, Class: dto.IN}}, rcode: dto.NXDOMAIN, authority: []dto.Record{negativeSOA}},
+		{name: "no data", questions: []dto.Question{{Name: "nodata.example", Type: dto.A, Class: dto.IN}}, rcode: dto.NOERROR, authority: []dto.Record{negativeSOA}},
+		{name: "without soa", questions: []dto.Question{{Name: "nxdomain", Type: dto.A, Class: dto.IN}}, rcode: dto.NXDOMAIN},
+		{name: "found", questions: []dto.Question{{Name: "localhost", Type: dto.A, Class: dto.IN}}, rcode: dto.NOERROR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chain.Resolve(context.Background(), dto.Message{
+				Header:        dto.STANDARD_QUERY,
+				QuestionCount: uint16(len(tt.questions)),
+				Question:      tt.questions,
+			})
+			if got.Header.RCode() != tt.rcode {
+				t.Errorf("ResolverChain.Resolve() rcode = %v, want %v", got.Header.RCode(), tt.rcode)
+			}
+			if !reflect.DeepEqual(got.Authority, tt.authority) || int(got.AuthorityCount) != len(tt.authority) {
+				t.Errorf("ResolverChain.Resolve() authority = %v, want %v", got.Authority, tt.authority)
+			}
+		})
+	}
+}
+
+func TestResolverChain_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got := NewResolverChain([]Resolver{statusResolver(Found)}).Resolve(ctx, dto.Message{
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "example.com", Type: dto.A, Class: dto.IN}},
+	})
+	if got.Header.RCode() != dto.SERVFAIL || got.ResponseCount != 0 {
+		t.Errorf("ResolverChain.Resolve() = %v, want a SERVFAIL without answer", got)
+	}
+}
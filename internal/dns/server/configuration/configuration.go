@@ -5,9 +5,39 @@ type udpEndpoint struct {
 	Address string `json:"address"`
 }
 
-type externalSource struct {
+// ExternalSource an upstream server, Type is DOH for the json api, DOH_WIRE for rfc8484 dns over https, DOT for dns over tls, DOQ for rfc9250 dns over quic, TCP or UDP.
+// RECURSIVE resolves the names from the root servers instead of forwarding them, Endpoint is then ignored
+type ExternalSource struct {
 	Type     string `json:"type"`
 	Endpoint string `json:"endpoint"`
+	// Method http method used by DOH_WIRE, GET or POST, GET when empty
+	Method string `json:"method,omitempty"`
+	// ServerName name of the DOT or DOQ server verified in its certificate
+	ServerName string `json:"server_name,omitempty"`
+	// Pins base64 sha256 digests of the public keys accepted for the DOT server
+	Pins []string `json:"spki_pins,omitempty"`
+	// Retries number of times an unanswered UDP query is sent again before failing
+	Retries int `json:"retries,omitempty"`
+	// Bootstrap addresses of the DOH and DOH_WIRE server, dialed instead of resolving the host of the endpoint
+	Bootstrap []string `json:"bootstrap,omitempty"`
+	// Timeout in milliseconds of a DOH and DOH_WIRE request when the query has no deadline, the default is used when zero
+	Timeout uint32 `json:"timeout_ms,omitempty"`
+	// CABundle path of a pem file with the certificate authorities trusted for DOH, DOH_WIRE, DOT and DOQ, the system ones when empty
+	CABundle string `json:"ca_bundle,omitempty"`
+}
+
+// health the upstreams are probed periodically, they are not queried anymore after consecutive transport failures until a probe succeeds
+type health struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// Interval in milliseconds between two probes
+	Interval uint32 `json:"interval_ms,omitempty"`
+	// Timeout in milliseconds of a probe
+	Timeout uint32 `json:"timeout_ms,omitempty"`
+	// Threshold number of consecutive failures to reach an upstream after which it is down, error codes answered are not failures
+	Threshold int32 `json:"threshold,omitempty"`
+	// Probe name whose NS records are asked to the upstreams, the root when empty.
+	// The upstreams of a forwarded zone are asked the SOA of their zone instead
+	Probe string `json:"probe,omitempty"`
 }
 
 type custom struct {
@@ -27,9 +57,25 @@ type ServerConf struct {
 	BlockingLists []string       `json:"blocking_list"`
 	Custom        []custom       `json:"custom"`
 	Cache         cache          `json:"cache"`
-	External      externalSource `json:"external"`
+	External      ExternalSource `json:"external"`
 	Endpoint      udpEndpoint    `json:"endpoint"`
 	Memdump       string         `json:"memdump,omitempty"`
+	// Upstreams replace External when not empty, the upstream of each query is chosen by the Strategy
+	Upstreams []ExternalSource `json:"upstreams,omitempty"`
+	// Forwarding maps domain suffixes to the upstreams resolving the names under them instead of External, the longest suffix wins
+	Forwarding map[string][]ExternalSource `json:"forwarding,omitempty"`
+	// Strategy failover, round_robin, lowest_latency or race, failover when empty
+	Strategy string `json:"strategy,omitempty"`
+	// Race number of upstreams asked at the same time by the race strategy
+	Race int `json:"race,omitempty"`
+	// QueryTimeout maximum time in milliseconds spent to answer a query, the default is used when zero
+	QueryTimeout uint32 `json:"query_timeout_ms,omitempty"`
+	// PrivateReverse answers the reverse lookups of private addresses missing in Custom with NXDOMAIN instead of forwarding them
+	PrivateReverse bool `json:"private_reverse_nxdomain"`
+	// Health checking of the upstreams
+	Health health `json:"health"`
+	// Metrics address on which the metrics are served over http at /debug/vars, disabled when empty
+	Metrics string `json:"metrics,omitempty"`
 }
 
 // Default generate the default configuration
@@ -39,23 +85,29 @@ func Default() ServerConf {
 		BlockingLists: []string{
 			"https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts",
 		},
-		Custom: []custom{
-			{"cloudflare-dns.com", "104.16.249.249"},
-			{"cloudflare-dns.com", "2606:4700::6810:f8f"},
-		},
+		Custom: []custom{},
 		Cache: cache{
 			Size:         1000000,
 			Basettl:      600,
 			ForceBasettl: true,
 		},
-		External: externalSource{
-			Type:     "DOH",
-			Endpoint: "https://cloudflare-dns.com/dns-query",
+		External: ExternalSource{
+			Type:      "DOH",
+			Endpoint:  "https://cloudflare-dns.com/dns-query",
+			Bootstrap: []string{"1.1.1.1", "1.0.0.1", "2606:4700:4700::1111"},
 		},
 		Endpoint: udpEndpoint{
 			Enabled: true,
 			Address: "127.0.0.1:53",
 		},
+		QueryTimeout:   2000,
+		PrivateReverse: true,
+		Health: health{
+			Interval:  10000,
+			Timeout:   2000,
+			Threshold: 3,
+			Probe:     ".",
+		},
 	}
 }
 
@@ -2,6 +2,7 @@ package udpendpoint
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"log"
 	"net"
@@ -28,15 +29,16 @@ type question struct {
 	arrival     time.Time
 }
 
-// NewUDPEndpoint create a new udp enpoint with the given chain
-func NewUDPEndpoint(address string, chain *resolver.ResolverChain) *UDPEndpoint {
+// NewUDPEndpoint create a new udp enpoint with the given chain, each query must be answered within the timeout
+func NewUDPEndpoint(address string, chain *resolver.ResolverChain, timeout time.Duration) *UDPEndpoint {
 	return &UDPEndpoint{
 		laddr:      address,
 		chain:      chain,
+		timeout:    timeout,
 		lock:       sync.RWMutex{},
 		started:    atomic.Bool{},
 		inbox:      make(chan question, maxPending),
-		bufferPool: sync.Pool{New: func() any { return make([]byte, dto.BufferMaxLength) }},
+		bufferPool: sync.Pool{New: func() any { return make([]byte, dto.UDPMaxLength) }},
 	}
 }
 
@@ -44,6 +46,7 @@ func NewUDPEndpoint(address string, chain *resolver.ResolverChain) *UDPEndpoint
 type UDPEndpoint struct {
 	laddr      string
 	chain      *resolver.ResolverChain
+	timeout    time.Duration
 	lock       sync.RWMutex
 	started    atomic.Bool
 	inbox      chan question
@@ -109,39 +112,110 @@ func (e *UDPEndpoint) receive(udpConn *net.UDPConn) {
 		if err, ok := err.(net.Error); ok && (err.Timeout() || errors.Is(err, net.ErrClosed)) {
 			return
 		}
-		panic(err)
+		// a failing read must not stop the server, the next one is attempted
+		log.Println("error reading a query", err)
+		e.recycle(buff)
+		return
 	}
 	e.inbox <- question{message: buff[0:n], destination: *addr, arrival: time.Now()}
 }
 
+// session holds what a handler reuses from one request to the next, to avoid allocations
+type session struct {
+	query   dto.Message
+	payload []byte
+}
+
 func (e *UDPEndpoint) handler(ctx context.Context, udpConn *net.UDPConn, wg *sync.WaitGroup) {
 	defer wg.Done()
+	s := &session{payload: make([]byte, 0, dto.EDNSUDPLength)}
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case msg := <-e.inbox:
-			e.handleRequest(msg.message, &msg.destination, udpConn)
+			// the time spent waiting in the inbox counts in the deadline of the query
+			qctx, cancel := context.WithDeadline(ctx, msg.arrival.Add(e.timeout))
+			e.handleRequest(qctx, msg.message, s, &msg.destination, udpConn)
+			cancel()
 			e.recycle(msg.message)
 		}
 	}
 }
 
-func (e *UDPEndpoint) handleRequest(buffer []byte, dest *net.UDPAddr, udpConn *net.UDPConn) {
+func (e *UDPEndpoint) handleRequest(ctx context.Context, buffer []byte, s *session, dest *net.UDPAddr, udpConn *net.UDPConn) {
 	e.lock.RLock()
 	defer e.lock.RUnlock()
-	message, err := dto.ParseMessage(buffer)
+	message := &s.query
+	err := dto.ParseMessageInto(buffer, message)
 	if err != nil {
 		log.Println(err)
+		var formatError *dto.FormatError
+		if errors.As(err, &formatError) {
+			if res, ok := formatErrorResponse(buffer); ok {
+				send(res, dto.ClassicUDPLength, s, dest, udpConn)
+			}
+		}
 		return
 	}
-	res := e.chain.Resolve(*message)
-	send(res, dest, udpConn)
+	if edns, ok := message.EDNS(); ok && edns.Version > 0 {
+		send(badVersionResponse(message), clientUDPSize(edns), s, dest, udpConn)
+		return
+	}
+	res := e.chain.Resolve(ctx, *message)
+	maxSize := dto.ClassicUDPLength
+	if edns, ok := message.EDNS(); ok {
+		res.SetEDNS(dto.EDNS{UDPSize: dto.EDNSUDPLength})
+		maxSize = clientUDPSize(edns)
+	}
+	send(res, maxSize, s, dest, udpConn)
+}
+
+// formatErrorResponse build the FORMERR answer to a malformed query, packets which are not queries are not answered
+func formatErrorResponse(packet []byte) (dto.Message, bool) {
+	if len(packet) < 4 {
+		return dto.Message{}, false
+	}
+	query := dto.Header(binary.BigEndian.Uint16(packet[2:4]))
+	if query.QR() {
+		return dto.Message{}, false
+	}
+	var header dto.Header
+	header.SetQR(true)
+	header.SetOpcode(query.Opcode())
+	header.SetRD(query.RD())
+	header.SetRCode(dto.FORMERR)
+	return dto.Message{ID: binary.BigEndian.Uint16(packet[0:2]), Header: header}, true
+}
+
+// badVersionResponse build the BADVERS answer to a query using an EDNS version above 0,
+// the OPT record of the answer tells the client the version supported, see rfc6891 section 6.1.3
+func badVersionResponse(query *dto.Message) dto.Message {
+	var header dto.Header
+	header.SetQR(true)
+	header.SetOpcode(query.Header.Opcode())
+	header.SetRD(query.Header.RD())
+	res := dto.Message{ID: query.ID, Header: header, QuestionCount: query.QuestionCount, Question: query.Question}
+	res.SetEDNS(dto.EDNS{UDPSize: dto.EDNSUDPLength})
+	res.SetRCode(dto.BADVERS)
+	return res
+}
+
+// clientUDPSize returns the size of the biggest answer the client is able to receive
+func clientUDPSize(edns dto.EDNS) int {
+	size := int(edns.UDPSize)
+	if size < dto.ClassicUDPLength {
+		return dto.ClassicUDPLength
+	}
+	if size > dto.EDNSUDPLength {
+		return dto.EDNSUDPLength
+	}
+	return size
 }
 
-func send(message dto.Message, dest *net.UDPAddr, udpConn *net.UDPConn) bool {
-	payload := dto.SerializeMessage(message)
-	_, err := udpConn.WriteToUDP(payload, dest)
+func send(message dto.Message, maxSize int, s *session, dest *net.UDPAddr, udpConn *net.UDPConn) bool {
+	s.payload = dto.AppendTruncated(s.payload[:0], message, maxSize)
+	_, err := udpConn.WriteToUDP(s.payload, dest)
 	if err != nil {
 		if terr, ok := err.(net.Error); !(ok && terr.Timeout()) {
 			log.Println(err)
@@ -156,7 +230,7 @@ func (e *UDPEndpoint) getBuffer() []byte {
 }
 
 func (e *UDPEndpoint) recycle(buff []byte) {
-	e.bufferPool.Put(buff[0:dto.BufferMaxLength])
+	e.bufferPool.Put(buff[0:dto.UDPMaxLength])
 }
 
 func (e *UDPEndpoint) populateConn(ctx context.Context, n int) []*net.UDPConn {
@@ -176,11 +250,11 @@ func (e *UDPEndpoint) populateConn(ctx context.Context, n int) []*net.UDPConn {
 		if !ok {
 			panic("connection is not an udp connection")
 		}
-		err = udpConn.SetReadBuffer(dto.BufferMaxLength * workers * 2)
+		err = udpConn.SetReadBuffer(dto.UDPMaxLength * workers * 2)
 		if err != nil {
 			panic(err)
 		}
-		err = udpConn.SetWriteBuffer(dto.BufferMaxLength)
+		err = udpConn.SetWriteBuffer(dto.UDPMaxLength)
 		if err != nil {
 			panic(err)
 		}
@@ -2,6 +2,7 @@ package udpendpoint
 
 import (
 	"context"
+	"net"
 	"os"
 	"sync"
 	"testing"
@@ -9,12 +10,13 @@ import (
 
 	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
 	"github.com/bluguard/dnshield/internal/dns/client/udp"
+	"github.com/bluguard/dnshield/internal/dns/dto"
 	"github.com/bluguard/dnshield/internal/dns/resolver"
 )
 
 const addr = "127.0.0.1:12349"
 
-var client *udp.UDPClient = udp.NewUDPClient(addr)
+var client *udp.UDPClient = udp.NewUDPClient(addr, 0)
 
 func TestMain(m *testing.M) {
 
@@ -26,7 +28,7 @@ func TestMain(m *testing.M) {
 		resolver.NewClientresolver(&memoryClient, "inMemory"),
 	})
 
-	endpoint := NewUDPEndpoint(addr, chain)
+	endpoint := NewUDPEndpoint(addr, chain, time.Second)
 
 	endpoint.SetChain(chain)
 
@@ -49,7 +51,7 @@ func TestUdpEndpoint(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error resolving localhost in v4 %v", err)
 	}
-	if res.Name != "localhost" || res.Data.String() != "127.0.0.1" {
+	if len(res) != 1 || res[0].Name != "localhost" || res[0].Data.String() != "127.0.0.1" {
 		t.Fatalf("Expecting localhost -> 127.0.0.1, got %v", res)
 	}
 
@@ -57,7 +59,109 @@ func TestUdpEndpoint(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error resolving localhost in v6 %v", err)
 	}
-	if res.Name != "localhost" || res.Data.String() != "::1" {
+	if len(res) != 1 || res[0].Name != "localhost" || res[0].Data.String() != "::1" {
 		t.Fatalf("Expecting localhost -> ::1, got %v", res)
 	}
 }
+
+func TestUdpEndpointEDNS(t *testing.T) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	query := dto.Message{
+		ID:            42,
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "localhost", Type: dto.A, Class: dto.IN}},
+	}
+	query.SetEDNS(dto.EDNS{UDPSize: 4096})
+
+	if _, err := conn.Write(dto.SerializeMessage(query)); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := dto.ParseMessage(buffer[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edns, ok := res.EDNS()
+	if !ok {
+		t.Fatal("expecting an OPT record in the answer")
+	}
+	if edns.UDPSize != dto.EDNSUDPLength {
+		t.Fatalf("expecting advertised size %d, got %d", dto.EDNSUDPLength, edns.UDPSize)
+	}
+	if res.ResponseCount != 1 {
+		t.Fatalf("expecting 1 answer, got %d", res.ResponseCount)
+	}
+}
+
+func TestUdpEndpointBadVersion(t *testing.T) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	query := dto.Message{
+		ID:            43,
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "localhost", Type: dto.A, Class: dto.IN}},
+	}
+	query.SetEDNS(dto.EDNS{UDPSize: 4096, Version: 1})
+
+	if _, err := conn.Write(dto.SerializeMessage(query)); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := dto.ParseMessage(buffer[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != 43 || res.RCode() != dto.BADVERS || res.Header.RCode() != dto.NOERROR || res.ResponseCount != 0 {
+		t.Fatalf("expecting a BADVERS answer without records, got rcode %d in %v", res.RCode(), res)
+	}
+	if edns, ok := res.EDNS(); !ok || edns.Version != 0 {
+		t.Fatalf("expecting an OPT record of version 0 in the answer, got %v", edns)
+	}
+}
+
+func TestUdpEndpointFormatError(t *testing.T) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// query whose name is a pointer to itself
+	if _, err := conn.Write([]byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 0x0c, 0, 1, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, dto.UDPMaxLength)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := dto.ParseMessage(buffer[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != 0x1234 || !res.Header.QR() || res.Header.RCode() != dto.FORMERR {
+		t.Fatalf("expecting a FORMERR answer to 0x1234, got %v", res)
+	}
+}
@@ -2,10 +2,16 @@ package server
 
 import (
 	"context"
+	"crypto/x509"
+	"errors"
+	"expvar"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -14,8 +20,14 @@ import (
 	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/client/blocker"
 	"github.com/bluguard/dnshield/internal/dns/client/doh"
+	"github.com/bluguard/dnshield/internal/dns/client/doq"
+	"github.com/bluguard/dnshield/internal/dns/client/dot"
 	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/client/multiple"
+	"github.com/bluguard/dnshield/internal/dns/client/recursive"
+	"github.com/bluguard/dnshield/internal/dns/client/tcp"
 	"github.com/bluguard/dnshield/internal/dns/client/udp"
+	"github.com/bluguard/dnshield/internal/dns/dto"
 	"github.com/bluguard/dnshield/internal/dns/resolver"
 	"github.com/bluguard/dnshield/internal/dns/server/configuration"
 	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
@@ -23,12 +35,27 @@ import (
 	blockparser "github.com/bluguard/dnshield/internal/dns/util/blockParser"
 )
 
+// defaultQueryTimeout is used when the configuration does not set any query timeout
+const defaultQueryTimeout = 2 * time.Second
+
+// default health checking of the upstreams, used when the configuration does not set them
+const (
+	defaultProbeInterval = 10 * time.Second
+	defaultProbeTimeout  = 2 * time.Second
+	defaultDownThreshold = 3
+)
+
+// metrics published by the server, served at /debug/vars on the metrics address
+var metrics = expvar.NewMap("dnshield")
+
 type Server struct {
 	chain     resolver.ResolverChain
 	endpoints []endpoint.Endpoint
 	started   bool
 	//http controller
 	cancelFunc context.CancelFunc
+	// stopMetrics shuts the metrics server down, it is nil when the metrics are not served
+	stopMetrics func()
 }
 
 func (s *Server) Start(conf configuration.ServerConf) *sync.WaitGroup {
@@ -69,6 +96,11 @@ func (s *Server) Reconfigure(conf configuration.ServerConf) *sync.WaitGroup {
 	if s.cancelFunc != nil {
 		s.cancelFunc()
 	}
+	if s.stopMetrics != nil {
+		// the address of the metrics is released before the new configuration binds it again
+		s.stopMetrics()
+		s.stopMetrics = nil
+	}
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	s.cancelFunc = cancelFunc
@@ -77,13 +109,40 @@ func (s *Server) Reconfigure(conf configuration.ServerConf) *sync.WaitGroup {
 
 	cache := memorycache.NewMemoryCache(ctx, &wg, conf.Cache.Size, conf.Cache.Basettl, conf.Cache.ForceBasettl, 1*time.Minute)
 
-	blocker, initBlocker := buildBlocker(conf)
+	blockClient, initBlocker := buildBlocker(conf)
+
+	external := buildExternal(conf)
+	forwarding := buildForwarding(conf)
+	if !conf.Health.Disabled {
+		for zone, upstreams := range forwarding {
+			upstreams.StartHealthCheck(ctx, &wg, zoneHealthCheck(conf, zone))
+		}
+	}
+	var fallback resolver.Resolver
+	if external != nil {
+		if !conf.Health.Disabled {
+			external.StartHealthCheck(ctx, &wg, healthCheck(conf))
+		}
+		fallback = resolver.NewClientresolver(external, "External")
+		metrics.Set("upstreams", expvar.Func(func() any { return external.Stats() }))
+	}
+	metrics.Set("forwarding", expvar.Func(func() any {
+		res := make(map[string][]multiple.Stats, len(forwarding))
+		for zone, upstreams := range forwarding {
+			res[zone] = upstreams.Stats()
+		}
+		return res
+	}))
+	if conf.Metrics != "" {
+		s.stopMetrics = serveMetrics(ctx, &wg, conf.Metrics)
+	}
 
 	s.chain = *resolver.NewResolverChain([]resolver.Resolver{
-		resolver.NewClientresolver(blocker, "Block"),
+		resolver.NewClientresolver(blockClient, "Block"),
 		resolver.NewClientresolver(buildCustom(conf), "Custom"),
 		resolver.NewClientresolver(cache, "Cache"),
-		resolver.NewCacheFeeder(resolver.NewClientresolver(buildExternal(conf), "External"), cache),
+		// the identical questions missing the cache at the same time are resolved once
+		resolver.NewCoalescingResolver(resolver.NewCacheFeeder(resolver.NewForwardResolver("Forward", buildZones(conf, forwarding), fallback), cache)),
 	})
 
 	s.endpoints = createEndpoints(conf, &s.chain)
@@ -98,20 +157,193 @@ func (s *Server) Reconfigure(conf configuration.ServerConf) *sync.WaitGroup {
 
 func createEndpoints(conf configuration.ServerConf, chain *resolver.ResolverChain) []endpoint.Endpoint {
 	return []endpoint.Endpoint{
-		udpendpoint.NewUDPEndpoint(conf.Endpoint.Address, chain),
+		udpendpoint.NewUDPEndpoint(conf.Endpoint.Address, chain, queryTimeout(conf)),
+	}
+}
+
+func queryTimeout(conf configuration.ServerConf) time.Duration {
+	if conf.QueryTimeout == 0 {
+		return defaultQueryTimeout
+	}
+	return time.Duration(conf.QueryTimeout) * time.Millisecond
+}
+
+func healthCheck(conf configuration.ServerConf) multiple.HealthCheck {
+	res := multiple.HealthCheck{
+		Interval:  time.Duration(conf.Health.Interval) * time.Millisecond,
+		Timeout:   time.Duration(conf.Health.Timeout) * time.Millisecond,
+		Threshold: conf.Health.Threshold,
+		Probe:     dto.Question{Name: conf.Health.Probe, Type: dto.NS, Class: dto.IN},
+	}
+	if res.Interval == 0 {
+		res.Interval = defaultProbeInterval
+	}
+	if res.Timeout == 0 {
+		res.Timeout = defaultProbeTimeout
+	}
+	if res.Threshold <= 0 {
+		res.Threshold = defaultDownThreshold
+	}
+	if res.Probe.Name == "" {
+		res.Probe.Name = "."
+	}
+	return res
+}
+
+// zoneHealthCheck probes the upstreams of a forwarded zone with the SOA of the zone,
+// the internal servers of a zone usually refuse the questions outside of it
+func zoneHealthCheck(conf configuration.ServerConf, zone string) multiple.HealthCheck {
+	res := healthCheck(conf)
+	res.Probe = dto.Question{Name: zone, Type: dto.SOA, Class: dto.IN}
+	return res
+}
+
+// serveMetrics serves the published metrics over http until the context is done.
+// The returned function shuts the server down and returns once the address is released
+func serveMetrics(ctx context.Context, wg *sync.WaitGroup, address string) func() {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Println("error serving the metrics on", address, err)
+		return func() {}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Println("error serving the metrics on", address, err)
+		}
+	}()
+	stop := func() {
+		_ = server.Close()
+		_ = listener.Close()
+		<-done
 	}
+	context.AfterFunc(ctx, stop)
+	return stop
 }
 
-func buildExternal(conf configuration.ServerConf) client.Client {
+// buildExternal returns the upstreams, a single External is wrapped too so that its health is checked.
+// Nil is returned when the external resolution is not allowed
+func buildExternal(conf configuration.ServerConf) *multiple.MultipleClient {
 	if !conf.AllowExternal {
-		panic("unexpected")
+		return nil
+	}
+	sources := conf.Upstreams
+	if len(sources) == 0 {
+		sources = []configuration.ExternalSource{conf.External}
 	}
-	switch conf.External.Type {
-	case "DOH":
-		return doh.NewDOHClient(conf.External.Endpoint)
+	return buildMultiple(conf, sources)
+}
+
+// buildZones returns the resolvers of the forwarded zones.
+// The private reverse lookups are answered by the reverse zones, the longest suffix wins so the forwarded reverse zones of the local network are asked instead
+func buildZones(conf configuration.ServerConf, forwarding map[string]*multiple.MultipleClient) map[string]resolver.Resolver {
+	zones := make(map[string]resolver.Resolver, len(forwarding)+2)
+	if conf.PrivateReverse {
+		zones["in-addr.arpa"] = resolver.NewClientresolver(blocker.PrivateReverse{}, "PrivateReverse")
+		zones["ip6.arpa"] = resolver.NewClientresolver(blocker.PrivateReverse{}, "PrivateReverse")
+	}
+	for zone, upstreams := range forwarding {
+		zones[zone] = resolver.NewClientresolver(upstreams, "Forward "+zone)
+	}
+	return zones
+}
+
+// buildForwarding returns the upstreams of each forwarded zone
+func buildForwarding(conf configuration.ServerConf) map[string]*multiple.MultipleClient {
+	res := make(map[string]*multiple.MultipleClient, len(conf.Forwarding))
+	for zone, sources := range conf.Forwarding {
+		if len(sources) == 0 {
+			// the zone is kept so that its names are answered with SERVFAIL instead of leaking to External
+			log.Println("no upstream configured for the forwarded zone", zone)
+		}
+		res[zone] = buildMultiple(conf, sources)
+	}
+	return res
+}
+
+func buildMultiple(conf configuration.ServerConf, sources []configuration.ExternalSource) *multiple.MultipleClient {
+	upstreams := make([]multiple.Upstream, 0, len(sources))
+	for _, source := range sources {
+		c, err := buildUpstream(source)
+		if err != nil {
+			// the other upstreams are still used, without any the questions are answered with SERVFAIL
+			log.Println("ignoring the upstream", source.Endpoint, err)
+			continue
+		}
+		upstreams = append(upstreams, multiple.Upstream{Name: source.Endpoint, Client: c})
+	}
+	strategy := multiple.Strategy(conf.Strategy)
+	if strategy == "" {
+		strategy = multiple.Failover
+	}
+	return multiple.NewMultipleClient(strategy, conf.Race, upstreams...)
+}
+
+func buildUpstream(source configuration.ExternalSource) (client.Client, error) {
+	switch source.Type {
+	case "DOH", "DOH_WIRE":
+		conf, err := dohConfig(source)
+		if err != nil {
+			return nil, err
+		}
+		if source.Type == "DOH" {
+			return doh.NewDOHClient(conf)
+		}
+		return doh.NewWireClient(conf, strings.EqualFold(source.Method, http.MethodPost))
+	case "TCP":
+		return tcp.NewTCPClient(source.Endpoint), nil
+	case "RECURSIVE":
+		return recursive.NewRecursiveClient(recursive.Config{}), nil
+	case "DOT":
+		roots, err := loadRootCAs(source.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		return dot.NewDOTClient(dot.Config{Address: source.Endpoint, ServerName: source.ServerName, Pins: source.Pins, RootCAs: roots})
+	case "DOQ":
+		roots, err := loadRootCAs(source.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		return doq.NewDOQClient(doq.Config{Address: source.Endpoint, ServerName: source.ServerName, RootCAs: roots})
 	default:
-		return udp.NewUDPClient(conf.External.Endpoint)
+		return udp.NewUDPClient(source.Endpoint, source.Retries), nil
+	}
+}
+
+func dohConfig(source configuration.ExternalSource) (doh.Config, error) {
+	roots, err := loadRootCAs(source.CABundle)
+	if err != nil {
+		return doh.Config{}, err
+	}
+	return doh.Config{
+		Endpoint:  source.Endpoint,
+		Bootstrap: source.Bootstrap,
+		Timeout:   time.Duration(source.Timeout) * time.Millisecond,
+		RootCAs:   roots,
+	}, nil
+}
+
+// loadRootCAs returns the certificate authorities of the pem bundle, nil when there is no bundle so that the system ones are used
+func loadRootCAs(bundle string) (*x509.CertPool, error) {
+	if bundle == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(bundle)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(content) {
+		return nil, errors.New("no certificate found in " + bundle)
 	}
+	return roots, nil
 }
 
 func buildCustom(conf configuration.ServerConf) client.Client {
@@ -139,7 +371,7 @@ func buildBlocker(conf configuration.ServerConf) (client.Client, func()) {
 }
 
 //The optimal chain is
-// Client(Blocker) -> Client(Memory) -> Client(Cache) -> CacheFeeder((Multiple(Client(udp/https))))
+// Client(Blocker) -> Client(Memory) -> Client(Cache) -> Coalescing(CacheFeeder(Forward(zones, Multiple(Client(udp/https)))))
 
 func memDump(memprofile string) {
 	if memprofile != "" {